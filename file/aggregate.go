@@ -0,0 +1,150 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const countVar = "count"
+
+// Combine the records of a file into one record per distinct value of the
+// group by variables. The records are folded in as they are parsed, so files
+// with a large number of lines such as /proc/net/tcp can be summarized
+// without creating a metric for each line. For example, to count the sockets
+// per state:
+//
+//	"aggregate": {"by": ["state"]}
+//
+// Each resulting record has the group by variables, the variable 'count' with
+// the number of records in the group, and the sum for each of the variables
+// listed in sum. If a filter is set, then only the records where it is true
+// will be used.
+type aggregateConfig struct {
+	By     []string `json:"by"`
+	Filter string   `json:"filter"`
+	Sum    []string `json:"sum"`
+}
+
+func (c aggregateConfig) validate(columns []string, known bool) error {
+	used := append(append([]string{}, c.By...), c.Sum...)
+	if c.Filter != "" {
+		vars, err := checkExpr(c.Filter)
+		if err != nil {
+			return errors.New(fmt.Sprintf("filter: %v", err))
+		}
+		used = append(used, vars...)
+	}
+	for _, v := range used {
+		if v == "" {
+			return errors.New("variable names cannot be empty")
+		}
+		if known && !contains(columns, v) {
+			return errors.New(fmt.Sprintf("variable '%v' is not produced by the parser", v))
+		}
+	}
+	return nil
+}
+
+// Names of the variables for the aggregated records.
+func (c aggregateConfig) variables() []string {
+	vars := append([]string{countVar}, c.By...)
+	return append(vars, c.Sum...)
+}
+
+type aggregator struct {
+	config aggregateConfig
+	groups map[string]map[string]interface{}
+
+	// First error for a record, the remaining records will still be used.
+	err error
+}
+
+func newAggregator(config aggregateConfig) *aggregator {
+	return &aggregator{config: config, groups: map[string]map[string]interface{}{}}
+}
+
+func (a *aggregator) fail(err error) {
+	if a.err == nil {
+		a.err = err
+	}
+}
+
+func (a *aggregator) add(record map[string]interface{}) {
+	if a.config.Filter != "" {
+		result, err := eval(record, a.config.Filter)
+		ok := false
+		if err == nil {
+			ok, err = isTrue(result)
+		}
+		if err != nil {
+			a.fail(errors.New(fmt.Sprintf("aggregate filter: %v", err)))
+			return
+		}
+		if !ok {
+			return
+		}
+	}
+
+	values := make([]string, len(a.config.By))
+	for i, b := range a.config.By {
+		v, ok := record[b]
+		if !ok {
+			a.fail(errors.New(fmt.Sprintf("aggregate: missing variable '%v'", b)))
+			return
+		}
+		values[i] = fmt.Sprintf("%v", v)
+	}
+	key := strings.Join(values, "\x00")
+
+	g, ok := a.groups[key]
+	if !ok {
+		g = map[string]interface{}{countVar: 0.0}
+		for _, b := range a.config.By {
+			g[b] = record[b]
+		}
+		for _, s := range a.config.Sum {
+			g[s] = 0.0
+		}
+		a.groups[key] = g
+	}
+	g[countVar] = g[countVar].(float64) + 1
+	for _, s := range a.config.Sum {
+		v, ok := record[s].(float64)
+		if !ok {
+			a.fail(errors.New(fmt.Sprintf("aggregate: cannot sum non-numeric value for '%v': %v", s, record[s])))
+			continue
+		}
+		g[s] = g[s].(float64) + v
+	}
+}
+
+// Aggregated records sorted by the group by values.
+func (a *aggregator) records() []map[string]interface{} {
+	keys := []string{}
+	for k := range a.groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	rows := []map[string]interface{}{}
+	for _, k := range keys {
+		rows = append(rows, a.groups[k])
+	}
+	return rows
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAggregate(t *testing.T) {
+
+	Convey("aggregate records", t, func() {
+		cfg := newTableConfig([]string{"state", "bytes"}, 0)
+		cfg.Aggregate = &aggregateConfig{By: []string{"state"}, Sum: []string{"bytes"}}
+		rows, err := newParser(cfg).parseString("b 1\na 2\nb 3\nc 4\n")
+		So(err, ShouldBeNil)
+		So(rows, ShouldResemble, []map[string]interface{}{
+			{"state": "a", "count": 1.0, "bytes": 2.0},
+			{"state": "b", "count": 2.0, "bytes": 4.0},
+			{"state": "c", "count": 1.0, "bytes": 4.0},
+		})
+
+		cfg.Aggregate = &aggregateConfig{Filter: "{bytes},2,:gt"}
+		rows, err = newParser(cfg).parseString("b 1\na 2\nb 3\nc 4\n")
+		So(err, ShouldBeNil)
+		So(rows, ShouldResemble, []map[string]interface{}{{"count": 2.0}})
+
+		cfg.Aggregate = &aggregateConfig{By: []string{"bytes"}, Sum: []string{"state"}}
+		_, err = newParser(cfg).parseString("b 1\n")
+		So(err.Error(), ShouldEqual, "aggregate: cannot sum non-numeric value for 'state': b")
+	})
+
+	Convey("validate", t, func() {
+		cfg := newTableConfig([]string{"state", "bytes"}, 0)
+		cfg.Aggregate = &aggregateConfig{By: []string{"state"}, Sum: []string{"bytes"}}
+		So(cfg.validate(), ShouldBeNil)
+		vars, known := cfg.variables()
+		So(known, ShouldBeTrue)
+		So(vars, ShouldResemble, []string{"count", "state", "bytes"})
+
+		cfg.Aggregate = &aggregateConfig{By: []string{"port"}}
+		So(cfg.validate().Error(), ShouldEqual, "aggregate: variable 'port' is not produced by the parser")
+
+		cfg.Aggregate = &aggregateConfig{Filter: "{bytes},:gt"}
+		So(cfg.validate().Error(), ShouldEqual, "aggregate: filter: operator :gt needs at least two arguments on the stack")
+	})
+
+	Convey("summarize /proc/net/tcp", t, func() {
+		configs, err := loadSetfile("testdata/net-tcp.json")
+		So(err, ShouldBeNil)
+		So(validateSetfile("testdata/net-tcp.json", nil), ShouldBeEmpty)
+
+		values := map[string]interface{}{}
+		ctx := newCollectContext(log.New(), osFileSystem{})
+		for _, c := range *configs {
+			_, err := c.getMetricTypes()
+			So(err, ShouldBeNil)
+			ms, err := c.collectMetrics(ctx, nil)
+			So(err, ShouldBeNil)
+			for _, m := range ms {
+				values[m.Namespace().String()] = m.Data()
+			}
+		}
+		So(ctx.errors, ShouldBeEmpty)
+		So(values, ShouldResemble, map[string]interface{}{
+			"/netflix/linux/net/tcp/state/ESTABLISHED/sockets":  3.0,
+			"/netflix/linux/net/tcp/state/ESTABLISHED/tx_queue": 36.0,
+			"/netflix/linux/net/tcp/state/LISTEN/sockets":       5.0,
+			"/netflix/linux/net/tcp/state/LISTEN/tx_queue":      0.0,
+			"/netflix/linux/net/tcp/state/TIME_WAIT/sockets":    1.0,
+			"/netflix/linux/net/tcp/state/TIME_WAIT/tx_queue":   0.0,
+			"/netflix/linux/net/tcp/listen/22/sockets":          1.0,
+			"/netflix/linux/net/tcp/listen/80/sockets":          2.0,
+			"/netflix/linux/net/tcp/listen/3306/sockets":        1.0,
+			"/netflix/linux/net/tcp/listen/7777/sockets":        1.0,
+		})
+	})
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/intelsdi-x/snap-plugin-utilities/config"
+	"github.com/intelsdi-x/snap/control/plugin"
+	"github.com/intelsdi-x/snap/core"
+)
+
+const (
+	defaultCatalogTimeout = 5
+	defaultCatalogLimit   = 10000
+)
+
+// Bounds for scanning the current files when building the catalog so that
+// loading the plugin will not hang on a large or slow file system.
+type catalogLimits struct {
+	timeout    time.Duration
+	maxMetrics int
+}
+
+// Get the catalog limits from the plugin config. The second return value is
+// false if the catalog is not enabled.
+func getCatalogLimits(cfg interface{}) (catalogLimits, bool) {
+	limits := catalogLimits{defaultCatalogTimeout * time.Second, defaultCatalogLimit}
+	enabled := false
+	if v, err := config.GetConfigItem(cfg, "catalog"); err == nil {
+		enabled, _ = v.(bool)
+	}
+	if v, err := config.GetConfigItem(cfg, "catalog_timeout"); err == nil {
+		if seconds, ok := v.(int); ok {
+			limits.timeout = time.Duration(seconds) * time.Second
+		}
+	}
+	if v, err := config.GetConfigItem(cfg, "catalog_limit"); err == nil {
+		if n, ok := v.(int); ok {
+			limits.maxMetrics = n
+		}
+	}
+	return limits, enabled
+}
+
+// Find the concrete metrics that are currently available by collecting from
+// the files. The metric types for the namespace patterns are always included
+// so that metrics for files that show up later, e.g. new containers, can
+// still be collected. The concrete metrics are added after the patterns,
+// up to the limits.
+func buildCatalog(gen *setfileGeneration, fs fileSystem, limits catalogLimits) []plugin.MetricType {
+	catalog := append([]plugin.MetricType{}, gen.metricTypes...)
+	seen := map[string]bool{}
+	for _, m := range catalog {
+		seen[m.Namespace().String()] = true
+	}
+
+	ctx := newCollectContext(log.New(), fs)
+	ctx.deadline = time.Now().Add(limits.timeout)
+
+	concrete := []plugin.MetricType{}
+	for _, cfg := range gen.configs {
+		if ctx.expired() || len(concrete) >= limits.maxMetrics {
+			log.Warnf("catalog is incomplete, found %d metrics before reaching the limits", len(concrete))
+			break
+		}
+		ms, err := cfg.collectMetrics(ctx, nil)
+		if err != nil {
+			log.Warnf("failed to scan files for %s: %v", cfg.source, err)
+			continue
+		}
+		for _, m := range ms {
+			ns := core.NewNamespace(m.Namespace().Strings()...)
+			if seen[ns.String()] || len(concrete) >= limits.maxMetrics {
+				continue
+			}
+			seen[ns.String()] = true
+			concrete = append(concrete, plugin.MetricType{
+				Namespace_:   ns,
+				Unit_:        m.Unit(),
+				Description_: m.Description(),
+			})
+		}
+	}
+
+	sort.Sort(byNamespace(concrete))
+	log.Infof("found %d concrete metrics for the catalog", len(concrete))
+	return append(catalog, concrete...)
+}
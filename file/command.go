@@ -0,0 +1,271 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin"
+	"github.com/intelsdi-x/snap/core/cdata"
+	"github.com/intelsdi-x/snap/core/ctypes"
+)
+
+// Command that can be run from the plugin binary without snapd, for example
+// to debug a setfile.
+type Command func(args []string, out io.Writer) error
+
+// Get the command with the given name or nil if there is no such command. In
+// that case the arguments should be passed to snap.
+func GetCommand(name string) Command {
+	switch name {
+	case "run":
+		return runCommand
+	case "validate":
+		return validateCommand
+	default:
+		return nil
+	}
+}
+
+// Options for the plugin config that are shared by the commands.
+type configFlags struct {
+	setfile  *string
+	root     *string
+	rootMode *string
+	snapshot *string
+	typeTag  *string
+}
+
+func newConfigFlags(flags *flag.FlagSet) configFlags {
+	return configFlags{
+		setfile:  flags.String("setfile", "", "main configuration file for the plugin"),
+		root:     flags.String("root", "", "root directory prepended to all file patterns"),
+		rootMode: flags.String("root_mode", rootModePrefix, "either 'prefix' or 'overlay'"),
+		snapshot: flags.String("snapshot", "", "tar or zip archive of a host file system to read instead of local files"),
+		typeTag:  flags.String("type_tag", defaultTypeTag, "tag key used for the type of a metric"),
+	}
+}
+
+// Create the plugin config that would normally be provided by snap.
+func (c configFlags) pluginConfig() (plugin.ConfigType, error) {
+	if *c.setfile == "" {
+		return plugin.ConfigType{}, errors.New("setfile must be specified")
+	}
+
+	node := cdata.NewNode()
+	node.AddItem("setfile", ctypes.ConfigValueStr{Value: *c.setfile})
+	node.AddItem("root", ctypes.ConfigValueStr{Value: *c.root})
+	node.AddItem("root_mode", ctypes.ConfigValueStr{Value: *c.rootMode})
+	node.AddItem("snapshot", ctypes.ConfigValueStr{Value: *c.snapshot})
+	node.AddItem("type_tag", ctypes.ConfigValueStr{Value: *c.typeTag})
+	return plugin.ConfigType{ConfigDataNode: node}, nil
+}
+
+// Load a setfile and collect the metrics one or more times, printing the
+// results.
+func runCommand(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	flags.SetOutput(out)
+	cfgFlags := newConfigFlags(flags)
+	count := flags.Int("count", 1, "number of times to collect the metrics")
+	interval := flags.Duration("interval", 10*time.Second, "time to wait between collections")
+	format := flags.String("format", "table", "output format, either 'table' or 'json'")
+	prefix := flags.String("prefix", "", "only show metrics with a namespace starting with the prefix")
+	showErrors := flags.Bool("errors", false, "show errors for individual files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var printer func(io.Writer, []plugin.MetricType, []fileError) error
+	switch *format {
+	case "table":
+		printer = printTable
+	case "json":
+		printer = printJson
+	default:
+		return errors.New(fmt.Sprintf("unknown output format: '%v'", *format))
+	}
+
+	cfg, err := cfgFlags.pluginConfig()
+	if err != nil {
+		return err
+	}
+
+	f := NewFileCollector()
+	mts, err := f.GetMetricTypes(cfg)
+	if err != nil {
+		return err
+	}
+	for i := range mts {
+		mts[i].Config_ = cfg.ConfigDataNode
+	}
+
+	for i := 0; i < *count; i++ {
+		if i > 0 {
+			time.Sleep(*interval)
+		}
+
+		ms, err := f.CollectMetrics(mts)
+		if err != nil {
+			return err
+		}
+
+		filtered := []plugin.MetricType{}
+		for _, m := range ms {
+			if strings.HasPrefix(m.Namespace().String(), *prefix) {
+				filtered = append(filtered, m)
+			}
+		}
+		sort.Sort(byNamespace(filtered))
+
+		fileErrors := []fileError{}
+		if *showErrors {
+			fileErrors = f.fileErrors
+		}
+		if err := printer(out, filtered, fileErrors); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type byNamespace []plugin.MetricType
+
+func (ms byNamespace) Len() int {
+	return len(ms)
+}
+
+func (ms byNamespace) Less(i, j int) bool {
+	return ms[i].Namespace().String() < ms[j].Namespace().String()
+}
+
+func (ms byNamespace) Swap(i, j int) {
+	ms[i], ms[j] = ms[j], ms[i]
+}
+
+// Format tags as a sorted list of key=value pairs.
+func formatTags(tags map[string]string) string {
+	pairs := []string{}
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func printTable(out io.Writer, ms []plugin.MetricType, fileErrors []fileError) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tVALUE\tTAGS")
+	for _, m := range ms {
+		fmt.Fprintf(w, "%s\t%v\t%s\n", m.Namespace().String(), m.Data(), formatTags(m.Tags()))
+	}
+	for _, e := range fileErrors {
+		fmt.Fprintf(w, "# error: %v\n", e)
+	}
+	fmt.Fprintln(w)
+	return w.Flush()
+}
+
+// JSON cannot represent NaN or infinite values so those are encoded as
+// strings.
+func jsonValue(v interface{}) interface{} {
+	if f, ok := v.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+		return fmt.Sprintf("%v", f)
+	}
+	return v
+}
+
+func printJson(out io.Writer, ms []plugin.MetricType, fileErrors []fileError) error {
+	encoder := json.NewEncoder(out)
+	for _, m := range ms {
+		err := encoder.Encode(map[string]interface{}{
+			"timestamp": m.Timestamp().UnixNano() / int64(time.Millisecond),
+			"namespace": m.Namespace().String(),
+			"tags":      m.Tags(),
+			"value":     jsonValue(m.Data()),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, e := range fileErrors {
+		err := encoder.Encode(map[string]interface{}{
+			"file":  e.File,
+			"error": e.Err.Error(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check a setfile for problems and print the errors that are found.
+func validateCommand(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags.SetOutput(out)
+	setfile := flags.String("setfile", "", "main configuration file for the plugin")
+	samples := flags.String("samples", "", "directory or archive with sample files used to check variables")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *setfile == "" {
+		return errors.New("setfile must be specified")
+	}
+
+	var fs fileSystem
+	if *samples != "" {
+		var err error
+		fs, err = sampleFileSystem(*samples)
+		if err != nil {
+			return err
+		}
+	}
+
+	errs := validateSetfile(*setfile, fs)
+	for _, err := range errs {
+		fmt.Fprintln(out, err)
+	}
+	if len(errs) > 0 {
+		return errors.New(fmt.Sprintf("%d problems found in %s", len(errs), *setfile))
+	}
+	fmt.Fprintf(out, "%s: ok\n", *setfile)
+	return nil
+}
+
+// File system for sample files, either an archive or a directory that will
+// be used as the root.
+func sampleFileSystem(samples string) (fileSystem, error) {
+	info, err := os.Stat(samples)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return newFileRoot(osFileSystem{}, samples, rootModePrefix)
+	}
+	return loadSnapshot(samples)
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCommand(t *testing.T) {
+
+	Convey("GetCommand", t, func() {
+		So(GetCommand("run"), ShouldNotBeNil)
+		So(GetCommand("-stand-alone"), ShouldBeNil)
+	})
+
+	Convey("run table", t, func() {
+		out := &bytes.Buffer{}
+		err := runCommand([]string{
+			"-setfile", "testdata/docker.json",
+			"-snapshot", "testdata/host.tar.gz",
+			"-prefix", "/netflix/linux/docker/3f1a0c8e9b7d6a5f4e3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d4c3b2a10/cpu/",
+		}, out)
+		So(err, ShouldBeNil)
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		So(len(lines), ShouldEqual, 6)
+		So(lines[0], ShouldStartWith, "NAMESPACE")
+		So(lines[1], ShouldContainSubstring, "/cpu/processing_capacity  1.024")
+		So(lines[5], ShouldContainSubstring, "atlas.dstype=counter,id=user,name=cgroup.cpu.usageTime")
+	})
+
+	Convey("run json", t, func() {
+		out := &bytes.Buffer{}
+		err := runCommand([]string{
+			"-setfile", "testdata/fileconfig.json",
+			"-format", "json",
+			"-prefix", "/test/load",
+		}, out)
+		So(err, ShouldBeNil)
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		So(len(lines), ShouldEqual, 3)
+
+		m := map[string]interface{}{}
+		So(json.Unmarshal([]byte(lines[0]), &m), ShouldBeNil)
+		So(m["namespace"], ShouldEqual, "/test/load/avg01m")
+		So(m["value"], ShouldEqual, 0.0001)
+	})
+
+	Convey("run errors", t, func() {
+		out := &bytes.Buffer{}
+		err := runCommand([]string{"-format", "json"}, out)
+		So(err.Error(), ShouldEqual, "setfile must be specified")
+
+		err = runCommand([]string{"-setfile", "testdata/docker.json", "-format", "xml"}, out)
+		So(err.Error(), ShouldEqual, "unknown output format: 'xml'")
+	})
+
+	Convey("jsonValue", t, func() {
+		So(jsonValue(1.0), ShouldEqual, 1.0)
+		So(jsonValue("foo"), ShouldEqual, "foo")
+		So(jsonValue(math.NaN()), ShouldEqual, "NaN")
+		So(jsonValue(math.Inf(1)), ShouldEqual, "+Inf")
+	})
+}
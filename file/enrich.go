@@ -0,0 +1,507 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Adds variables for a record based on metadata that is looked up using the
+// value of a variable that is already resolved. For example, to add the name
+// and image for docker containers:
+//
+//	"enrich": [
+//	  {
+//	    "provider": "docker",
+//	    "key": "container",
+//	    "labels": {"app": "com.netflix.app"},
+//	    "drop_unknown": true
+//	  }
+//	]
+//
+// The container metadata providers add the variables 'container_name' and
+// 'image', along with a variable for each of the selected labels. If the
+// metadata cannot be found, then the variables will be empty unless
+// drop_unknown is set, in which case the record will be skipped.
+type enrichConfig struct {
+	Provider string `json:"provider"`
+
+	// Variable with the id used to lookup the metadata. If not set, then
+	// the path of the matched file will be used.
+	Key string `json:"key"`
+
+	// Directory with the state files, defaults to the standard location
+	// for the provider.
+	Root string `json:"root"`
+
+	// Map of variable name to the label that should be used for the value.
+	Labels map[string]string `json:"labels"`
+
+	DropUnknown bool `json:"drop_unknown"`
+}
+
+// Source of metadata stored in a state file for each id.
+type metadataProvider interface {
+	// Default directory with the state files.
+	defaultRoot() string
+
+	// Get the id for the value of the key along with any variables that
+	// can be determined from the value itself. Returns false if the value
+	// does not have an id.
+	resolve(value string) (string, map[string]string, bool)
+
+	// Path of the state file for an id. Returns an empty string if it
+	// cannot be found.
+	locate(fs fileSystem, root string, id string) (string, error)
+
+	// Parse the contents of a state file. The file system can be used if
+	// other files next to the state file are needed. If the state is a
+	// directory, then the data will be nil.
+	parse(fs fileSystem, file string, data []byte) (*metadata, error)
+
+	// Names of the variables that will be added, not including labels.
+	variables() []string
+}
+
+// Metadata parsed from a state file.
+type metadata struct {
+	values map[string]string
+	labels map[string]string
+}
+
+var metadataProviders = map[string]metadataProvider{
+	"docker":     dockerProvider{},
+	"containerd": containerdProvider{},
+	"kubernetes": kubernetesProvider{},
+}
+
+func providerNames() []string {
+	names := []string{}
+	for k := range metadataProviders {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (e enrichConfig) validate() error {
+	if _, ok := metadataProviders[e.Provider]; !ok {
+		msg := fmt.Sprintf("unknown provider: '%v', expected one of %v", e.Provider, providerNames())
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// Names of the variables that will be added by the enrichment.
+func (e enrichConfig) variables() []string {
+	vars := []string{}
+	if p, ok := metadataProviders[e.Provider]; ok {
+		vars = append(vars, p.variables()...)
+	}
+	for k := range e.Labels {
+		vars = append(vars, k)
+	}
+	sort.Strings(vars)
+	return vars
+}
+
+func (e enrichConfig) root() string {
+	if e.Root != "" {
+		return e.Root
+	}
+	return metadataProviders[e.Provider].defaultRoot()
+}
+
+// Add the variables for the metadata to the vars. The second return value is
+// false if the record should be dropped.
+func (e enrichConfig) enrich(ctx *collectContext, file string, vars map[string]interface{}) (map[string]interface{}, bool, error) {
+	p := metadataProviders[e.Provider]
+	value := file
+	if e.Key != "" {
+		value = ""
+		if v, ok := vars[e.Key]; ok {
+			value = fmt.Sprintf("%v", v)
+		}
+	}
+
+	var m *metadata
+	id, derived, ok := p.resolve(value)
+	if ok {
+		file, err := p.locate(ctx.fs, e.root(), id)
+		if err == nil && file != "" {
+			m, err = ctx.metadata.get(ctx.fs, file, p)
+		}
+		if err != nil {
+			return vars, !e.DropUnknown, err
+		}
+	}
+	if m == nil && e.DropUnknown {
+		return vars, false, nil
+	}
+
+	result := map[string]interface{}{}
+	for k, v := range vars {
+		result[k] = v
+	}
+	for _, name := range p.variables() {
+		result[name] = ""
+	}
+	for k := range e.Labels {
+		result[k] = ""
+	}
+	for k, v := range derived {
+		result[k] = v
+	}
+	if m != nil {
+		for k, v := range m.values {
+			result[k] = v
+		}
+		for k, label := range e.Labels {
+			result[k] = m.labels[label]
+		}
+	}
+	return result, true, nil
+}
+
+// Apply the enrichments of the file config to the vars for a record. Errors
+// reading the state files are recorded on the context.
+func (c fileConfig) enrich(ctx *collectContext, file string, vars map[string]interface{}) (map[string]interface{}, bool) {
+	for _, e := range c.Enrich {
+		v, keep, err := e.enrich(ctx, file, vars)
+		if err != nil {
+			ctx.fileError(file, c.sourceError(errors.New(fmt.Sprintf("enrich: %v", err))))
+		}
+		if !keep {
+			return vars, false
+		}
+		vars = v
+	}
+	return vars, true
+}
+
+// Cache of the parsed state files. An entry is reloaded if the modification
+// time of the file changes and removed if the file no longer exists or was
+// not used by the last collection.
+type metadataCache struct {
+	mu      sync.Mutex
+	entries map[string]*metadataEntry
+}
+
+type metadataEntry struct {
+	mtime    time.Time
+	metadata *metadata
+	used     bool
+}
+
+func newMetadataCache() *metadataCache {
+	return &metadataCache{entries: map[string]*metadataEntry{}}
+}
+
+// Get the metadata for a state file. Returns nil if the file does not exist.
+func (c *metadataCache) get(fs fileSystem, file string, p metadataProvider) (*metadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, err := fs.stat(file)
+	if err != nil {
+		delete(c.entries, file)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if entry, ok := c.entries[file]; ok && entry.mtime.Equal(info.ModTime()) {
+		entry.used = true
+		return entry.metadata, nil
+	}
+
+	var data []byte
+	if !info.IsDir() {
+		data, err = fs.readFile(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	m, err := p.parse(fs, file, data)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %v", file, err))
+	}
+	c.entries[file] = &metadataEntry{info.ModTime(), m, true}
+	return m, nil
+}
+
+// Remove the entries that have not been used since the last sweep.
+func (c *metadataCache) sweep() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, entry := range c.entries {
+		if !entry.used {
+			delete(c.entries, k)
+		}
+		entry.used = false
+	}
+}
+
+var containerIDPattern = regexp.MustCompile("[0-9a-f]{64}")
+
+// Get the container id from a value that is either the id or a path with the
+// id as one of the segments, e.g. a cgroup path such as
+// '/sys/fs/cgroup/cpu/system.slice/docker-<id>.scope/cpu.shares'.
+func containerID(value string) (string, map[string]string, bool) {
+	if !strings.Contains(value, "/") {
+		return value, nil, value != ""
+	}
+	ids := containerIDPattern.FindAllString(value, -1)
+	if len(ids) == 0 {
+		return "", nil, false
+	}
+	return ids[len(ids)-1], nil, true
+}
+
+// Reads the config.v2.json file the docker daemon stores for each container.
+type dockerProvider struct{}
+
+func (dockerProvider) defaultRoot() string {
+	return "/var/lib/docker/containers"
+}
+
+func (dockerProvider) resolve(value string) (string, map[string]string, bool) {
+	return containerID(value)
+}
+
+func (dockerProvider) locate(fs fileSystem, root string, id string) (string, error) {
+	return path.Join(root, id, "config.v2.json"), nil
+}
+
+func (dockerProvider) parse(fs fileSystem, file string, data []byte) (*metadata, error) {
+	doc := struct {
+		Name   string
+		Config struct {
+			Image  string
+			Labels map[string]string
+		}
+	}{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	values := map[string]string{
+		"container_name": strings.TrimPrefix(doc.Name, "/"),
+		"image":          doc.Config.Image,
+	}
+	return &metadata{values, doc.Config.Labels}, nil
+}
+
+func (dockerProvider) variables() []string {
+	return []string{"container_name", "image"}
+}
+
+// Reads the OCI runtime spec that containerd stores for each task. The name
+// and image are taken from the annotations set by the CRI plugin, all
+// annotations can be used as labels.
+type containerdProvider struct{}
+
+const (
+	criContainerName = "io.kubernetes.cri.container-name"
+	criImageName     = "io.kubernetes.cri.image-name"
+)
+
+func (containerdProvider) defaultRoot() string {
+	return "/run/containerd/io.containerd.runtime.v2.task/k8s.io"
+}
+
+func (containerdProvider) resolve(value string) (string, map[string]string, bool) {
+	return containerID(value)
+}
+
+func (containerdProvider) locate(fs fileSystem, root string, id string) (string, error) {
+	return path.Join(root, id, "config.json"), nil
+}
+
+func (containerdProvider) parse(fs fileSystem, file string, data []byte) (*metadata, error) {
+	doc := struct {
+		Annotations map[string]string `json:"annotations"`
+	}{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	values := map[string]string{
+		"container_name": doc.Annotations[criContainerName],
+		"image":          doc.Annotations[criImageName],
+	}
+	return &metadata{values, doc.Annotations}, nil
+}
+
+func (containerdProvider) variables() []string {
+	return []string{"container_name", "image"}
+}
+
+// Determines the pod for a cgroup path created by the kubelet and gets the
+// namespace, pod and container names from the CRI state of the container.
+// Both the cgroupfs and systemd layouts are supported, for example:
+//
+//	/kubepods/burstable/pod<uid>/<container id>
+//	/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod<uid>.slice/cri-containerd-<container id>.scope
+//
+// The root is the root of the host file system, '/', and the state is read
+// from the OCI runtime spec containerd stores for each task, the same file
+// used by the containerd provider, using the annotations set by the CRI
+// plugin:
+//
+//	run/containerd/io.containerd.runtime.v2.task/k8s.io/<container id>/config.json
+//
+// The pod directories of the kubelet, /var/lib/kubelet/pods/<uid>, are not
+// used since they only record the pod name and namespace in files that
+// depend on the network mode and service account of the pod. If there is no
+// CRI state, e.g. for a cgroup of the pod rather than a container or a
+// runtime other than containerd, then the names are taken from the log
+// directories of the kubelet instead:
+//
+//	var/log/pods/<namespace>_<pod>_<uid>/<container>/
+//	var/log/containers/<pod>_<namespace>_<container>-<container id>.log
+//
+// These only exist while the logs are retained, if they have been removed
+// the pod will be treated as unknown.
+type kubernetesProvider struct{}
+
+var kubepodsPattern = regexp.MustCompile(
+	"kubepods[^/]*/(?:kubepods-)?(?:(besteffort|burstable)[^/]*/)?(?:kubepods-(?:besteffort-|burstable-)?)?" +
+		"pod([0-9a-f_-]{36})(?:\\.slice)?(?:/(?:[a-z-]+-)?([0-9a-f]{64})(?:\\.scope)?)?")
+
+// Name of a pod log directory, <namespace>_<pod>_<uid>. The names and uid
+// cannot contain '_'.
+var podLogDirPattern = regexp.MustCompile("^([^_]+)_([^_]+)_([0-9a-f-]{36})$")
+
+const (
+	criSandboxName      = "io.kubernetes.cri.sandbox-name"
+	criSandboxNamespace = "io.kubernetes.cri.sandbox-namespace"
+
+	podLogRoot = "/var/log"
+)
+
+func (kubernetesProvider) defaultRoot() string {
+	return "/"
+}
+
+// The id is the pod uid followed by '/<container id>' if the path is for a
+// container.
+func (kubernetesProvider) resolve(value string) (string, map[string]string, bool) {
+	m := kubepodsPattern.FindStringSubmatch(value)
+	if m == nil {
+		return "", nil, false
+	}
+	qos := m[1]
+	if qos == "" {
+		qos = "guaranteed"
+	}
+	uid := strings.Replace(m[2], "_", "-", -1)
+	values := map[string]string{
+		"pod_uid":      uid,
+		"qos_class":    qos,
+		"container_id": m[3],
+	}
+	id := uid
+	if m[3] != "" {
+		id += "/" + m[3]
+	}
+	return id, values, true
+}
+
+// The state is the CRI config of the container if it exists, otherwise the
+// log directory of the pod, or of the container if the id has a container
+// id.
+func (kubernetesProvider) locate(fs fileSystem, root string, id string) (string, error) {
+	uid, cid := id, ""
+	if i := strings.Index(id, "/"); i >= 0 {
+		uid, cid = id[:i], id[i+1:]
+	}
+	if cid != "" {
+		file, _ := containerdProvider{}.locate(fs, path.Join(root, containerdProvider{}.defaultRoot()), cid)
+		if _, err := fs.stat(file); err == nil {
+			return file, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+
+	logs := path.Join(root, podLogRoot)
+	pods, err := fs.glob(path.Join(logs, "pods", "*_*_"+uid))
+	if err != nil || len(pods) == 0 {
+		return "", err
+	}
+	if cid == "" {
+		return pods[0], nil
+	}
+
+	suffix := "-" + cid + ".log"
+	links, err := fs.glob(path.Join(logs, "containers", "*_*_*"+suffix))
+	if err != nil || len(links) == 0 {
+		return "", err
+	}
+	parts := strings.SplitN(strings.TrimSuffix(path.Base(links[0]), suffix), "_", 3)
+	if len(parts) != 3 {
+		return "", nil
+	}
+	return path.Join(pods[0], parts[2]), nil
+}
+
+// Parse the CRI config of a container, or the names from a log directory if
+// there is no data. The annotations of the CRI config can be used as labels.
+func (kubernetesProvider) parse(fs fileSystem, file string, data []byte) (*metadata, error) {
+	if data != nil {
+		doc := struct {
+			Annotations map[string]string `json:"annotations"`
+		}{}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		values := map[string]string{
+			"pod_namespace":  doc.Annotations[criSandboxNamespace],
+			"pod_name":       doc.Annotations[criSandboxName],
+			"container_name": doc.Annotations[criContainerName],
+		}
+		return &metadata{values, doc.Annotations}, nil
+	}
+
+	dir, container := file, ""
+	m := podLogDirPattern.FindStringSubmatch(path.Base(dir))
+	if m == nil {
+		dir, container = path.Dir(file), path.Base(file)
+		m = podLogDirPattern.FindStringSubmatch(path.Base(dir))
+	}
+	if m == nil {
+		return nil, errors.New("not a pod log directory")
+	}
+	values := map[string]string{
+		"pod_namespace":  m[1],
+		"pod_name":       m[2],
+		"container_name": container,
+	}
+	return &metadata{values, map[string]string{}}, nil
+}
+
+func (kubernetesProvider) variables() []string {
+	return []string{"container_id", "container_name", "pod_name", "pod_namespace", "pod_uid", "qos_class"}
+}
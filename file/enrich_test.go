@@ -0,0 +1,290 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const dockerConfig = `{
+  "ID": "abc",
+  "Name": "/web",
+  "Config": {
+    "Image": "nginx:1.11",
+    "Labels": {"com.netflix.app": "frontend"}
+  }
+}`
+
+func TestEnrich(t *testing.T) {
+
+	newConfig := func(dropUnknown bool) fileConfig {
+		return fileConfig{
+			File:   "/cgroup/{container}/usage",
+			Parser: newTableConfig([]string{"value"}, 0),
+			Enrich: []enrichConfig{{
+				Provider:    "docker",
+				Key:         "container",
+				Root:        "/docker",
+				Labels:      map[string]string{"app": "com.netflix.app"},
+				DropUnknown: dropUnknown,
+			}},
+			Tags: map[string]string{"image": "{image}", "app": "{app}"},
+			Metrics: map[string]metricConfig{
+				"/test/{container_name}/usage": newMetricConfig("{value}"),
+			},
+			source: "a.json entry 0",
+		}
+	}
+
+	Convey("enrich with docker metadata", t, func() {
+		fs := newMemFileSystem()
+		fs.add("/cgroup/abc/usage", []byte("10\n"), time.Now())
+		fs.add("/cgroup/def/usage", []byte("20\n"), time.Now())
+		fs.add("/docker/abc/config.v2.json", []byte(dockerConfig), time.Now())
+
+		c := newConfig(true)
+		_, err := c.getMetricTypes()
+		So(err, ShouldBeNil)
+
+		ctx := newCollectContext(log.New(), fs)
+		ms, err := c.collectMetrics(ctx, nil)
+		So(err, ShouldBeNil)
+		So(ctx.errors, ShouldBeEmpty)
+		So(len(ms), ShouldEqual, 1)
+		So(ms[0].Namespace().String(), ShouldEqual, "/test/web/usage")
+		So(ms[0].Tags()["image"], ShouldEqual, "nginx:1.11")
+		So(ms[0].Tags()["app"], ShouldEqual, "frontend")
+	})
+
+	Convey("unknown containers have empty metadata", t, func() {
+		fs := newMemFileSystem()
+		fs.add("/cgroup/def/usage", []byte("20\n"), time.Now())
+
+		c := newConfig(false)
+		c.Metrics = map[string]metricConfig{"/test/{container}/usage": newMetricConfig("{value}")}
+		ctx := newCollectContext(log.New(), fs)
+		ms, err := c.collectMetrics(ctx, nil)
+		So(err, ShouldBeNil)
+		So(len(ms), ShouldEqual, 1)
+		So(ms[0].Tags()["image"], ShouldEqual, "")
+	})
+
+	Convey("metadata cache", t, func() {
+		fs := newMemFileSystem()
+		file := "/docker/abc/config.v2.json"
+		fs.add(file, []byte(dockerConfig), time.Unix(100, 0))
+		p := dockerProvider{}
+
+		cache := newMetadataCache()
+		m, err := cache.get(fs, file, p)
+		So(err, ShouldBeNil)
+		So(m.values["container_name"], ShouldEqual, "web")
+
+		// Not reloaded unless the modification time changes
+		fs.add(file, []byte(`{"Name": "/api"}`), time.Unix(100, 0))
+		m, _ = cache.get(fs, file, p)
+		So(m.values["container_name"], ShouldEqual, "web")
+		fs.add(file, []byte(`{"Name": "/api"}`), time.Unix(200, 0))
+		m, _ = cache.get(fs, file, p)
+		So(m.values["container_name"], ShouldEqual, "api")
+
+		m, err = cache.get(fs, "/docker/def/config.v2.json", p)
+		So(err, ShouldBeNil)
+		So(m, ShouldBeNil)
+
+		cache.sweep()
+		So(cache.entries, ShouldContainKey, file)
+		cache.sweep()
+		So(cache.entries, ShouldNotContainKey, file)
+
+		fs.add(file, []byte(`{`), time.Unix(300, 0))
+		_, err = cache.get(fs, file, p)
+		So(err.Error(), ShouldStartWith, file+": ")
+	})
+
+	Convey("containerd metadata", t, func() {
+		data := `{"annotations": {"io.kubernetes.cri.container-name": "web", "io.kubernetes.cri.image-name": "nginx:1.11"}}`
+		m, err := containerdProvider{}.parse(nil, "config.json", []byte(data))
+		So(err, ShouldBeNil)
+		So(m.values["container_name"], ShouldEqual, "web")
+		So(m.values["image"], ShouldEqual, "nginx:1.11")
+	})
+
+	Convey("validate", t, func() {
+		So(enrichConfig{Provider: "rkt", Key: "id"}.validate().Error(), ShouldEqual, "unknown provider: 'rkt', expected one of [containerd docker kubernetes]")
+		So(enrichConfig{Provider: "docker"}.validate(), ShouldBeNil)
+
+		c := newConfig(true)
+		So(validateFileConfig(setfileEntry{"a.json", 0, 2, c}, nil), ShouldBeEmpty)
+
+		c.Enrich[0].Key = "id"
+		errs := validateFileConfig(setfileEntry{"a.json", 0, 2, c}, nil)
+		So(errorStrings(errs), ShouldResemble, []string{
+			"a.json:2: entry 0: enrich[0]: variable 'id' is not produced by the parser",
+		})
+	})
+}
+
+func TestKubernetes(t *testing.T) {
+
+	Convey("resolve pod from cgroup path", t, func() {
+		p := kubernetesProvider{}
+		uid := "6b9f0c2e-1d3a-4e5f-8a7b-9c0d1e2f3a4b"
+		cid := "7c2e9f4a1b3d5e6f8a0b2c4d6e8f0a1b3c5d7e9f1a2b4c6d8e0f2a4b6c8d0e1f"
+
+		id, values, ok := p.resolve("/sys/fs/cgroup/cpu/kubepods/burstable/pod" + uid + "/" + cid + "/cpu.shares")
+		So(ok, ShouldBeTrue)
+		So(id, ShouldEqual, uid+"/"+cid)
+		So(values, ShouldResemble, map[string]string{"pod_uid": uid, "qos_class": "burstable", "container_id": cid})
+
+		id, values, _ = p.resolve("/sys/fs/cgroup/cpu/kubepods/pod" + uid + "/cpu.shares")
+		So(id, ShouldEqual, uid)
+		So(values, ShouldResemble, map[string]string{"pod_uid": uid, "qos_class": "guaranteed", "container_id": ""})
+
+		systemdUID := strings.Replace(uid, "-", "_", -1)
+		id, values, _ = p.resolve("/sys/fs/cgroup/cpu/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod" +
+			systemdUID + ".slice/cri-containerd-" + cid + ".scope/cpu.shares")
+		So(id, ShouldEqual, uid+"/"+cid)
+		So(values, ShouldResemble, map[string]string{"pod_uid": uid, "qos_class": "besteffort", "container_id": cid})
+
+		_, values, _ = p.resolve("/sys/fs/cgroup/cpu/kubepods.slice/kubepods-pod" + systemdUID + ".slice/docker-" + cid + ".scope/cpu.shares")
+		So(values, ShouldResemble, map[string]string{"pod_uid": uid, "qos_class": "guaranteed", "container_id": cid})
+
+		_, _, ok = p.resolve("/sys/fs/cgroup/cpu/docker/" + cid + "/cpu.shares")
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("locate CRI state of a container", t, func() {
+		p := kubernetesProvider{}
+		fs := osFileSystem{}
+		root := "testdata/k8s"
+		uid := "6b9f0c2e-1d3a-4e5f-8a7b-9c0d1e2f3a4b"
+		cid := "7c2e9f4a1b3d5e6f8a0b2c4d6e8f0a1b3c5d7e9f1a2b4c6d8e0f2a4b6c8d0e1f"
+
+		file, err := p.locate(fs, root, uid+"/"+cid)
+		So(err, ShouldBeNil)
+		So(file, ShouldEqual, root+"/run/containerd/io.containerd.runtime.v2.task/k8s.io/"+cid+"/config.json")
+		data, err := fs.readFile(file)
+		So(err, ShouldBeNil)
+		m, err := p.parse(fs, file, data)
+		So(err, ShouldBeNil)
+		So(m.values, ShouldResemble, map[string]string{
+			"pod_namespace":  "frontend",
+			"pod_name":       "web-5d8f7c9b6-xk2lp",
+			"container_name": "web",
+		})
+		So(m.labels[criImageName], ShouldEqual, "nginx:1.11")
+
+		// Sandbox containers only have the pod names
+		file, err = p.locate(fs, root, "9d8c7b6a-5f4e-4d3c-8b2a-1f0e9d8c7b6a/e1f2a3b4c5d6e7f8a9b0c1d2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1f2")
+		So(err, ShouldBeNil)
+		data, err = fs.readFile(file)
+		So(err, ShouldBeNil)
+		m, err = p.parse(fs, file, data)
+		So(err, ShouldBeNil)
+		So(m.values, ShouldResemble, map[string]string{
+			"pod_namespace":  "kube-system",
+			"pod_name":       "node-exporter-7xk4d",
+			"container_name": "",
+		})
+	})
+
+	Convey("fallback to pod and container log directories", t, func() {
+		p := kubernetesProvider{}
+		fs := osFileSystem{}
+		root := "testdata/k8s"
+		uid := "6b9f0c2e-1d3a-4e5f-8a7b-9c0d1e2f3a4b"
+		pod := root + "/var/log/pods/frontend_web-5d8f7c9b6-xk2lp_" + uid
+
+		file, err := p.locate(fs, root, uid)
+		So(err, ShouldBeNil)
+		So(file, ShouldEqual, pod)
+		m, err := p.parse(fs, file, nil)
+		So(err, ShouldBeNil)
+		So(m.values, ShouldResemble, map[string]string{
+			"pod_namespace":  "frontend",
+			"pod_name":       "web-5d8f7c9b6-xk2lp",
+			"container_name": "",
+		})
+
+		// Container names can contain '-'
+		file, err = p.locate(fs, root, uid+"/1f0e8d0c6b4a2f0e8d6c4b2a1f9e7d5c3b1a0f8e6d4c2b0a8f6e5d3b1a4f9e2c")
+		So(err, ShouldBeNil)
+		So(file, ShouldEqual, pod+"/log-shipper")
+		m, err = p.parse(fs, file, nil)
+		So(err, ShouldBeNil)
+		So(m.values["container_name"], ShouldEqual, "log-shipper")
+
+		// Neither CRI state nor logs
+		file, err = p.locate(fs, root, uid+"/f0e1d2c3b4a5f6e7d8c9b0a1f2e3d4c5b6a7f8e9d0c1b2a3f4e5d6c7b8a9f0e1")
+		So(err, ShouldBeNil)
+		So(file, ShouldEqual, "")
+
+		file, err = p.locate(fs, root, "0a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d")
+		So(err, ShouldBeNil)
+		So(file, ShouldEqual, "")
+
+		_, err = p.parse(fs, root+"/var/log/pods", nil)
+		So(err.Error(), ShouldEqual, "not a pod log directory")
+	})
+
+	Convey("enrich from fixture directory", t, func() {
+		configs, err := loadSetfile("testdata/k8s.json")
+		So(err, ShouldBeNil)
+		So(validateSetfile("testdata/k8s.json", nil), ShouldBeEmpty)
+
+		c := (*configs)[0]
+		_, err = c.getMetricTypes()
+		So(err, ShouldBeNil)
+
+		ctx := newCollectContext(log.New(), osFileSystem{})
+		ms, err := c.collectMetrics(ctx, nil)
+		So(err, ShouldBeNil)
+		So(ctx.errors, ShouldBeEmpty)
+
+		// The besteffort pod and the sandbox container of node-exporter
+		// only have CRI state and the other containers only have logs
+		So(len(ms), ShouldEqual, 5)
+		values := map[string]interface{}{}
+		containers := map[string]string{}
+		qos := map[string]string{}
+		for _, m := range ms {
+			values[m.Namespace().String()] = m.Data()
+			containers[m.Namespace()[5].Value] = m.Tags()["container"]
+			qos[m.Namespace()[3].Value] = m.Tags()["qos"]
+		}
+		cid := "7c2e9f4a1b3d5e6f8a0b2c4d6e8f0a1b3c5d7e9f1a2b4c6d8e0f2a4b6c8d0e1f"
+		So(values["/netflix/linux/k8s/frontend/web-5d8f7c9b6-xk2lp/"+cid+"/cpu/shares"], ShouldEqual, 512.0)
+		So(values["/netflix/linux/k8s/kube-system/node-exporter-7xk4d/c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5/cpu/shares"], ShouldEqual, 1024.0)
+		So(containers, ShouldResemble, map[string]string{
+			cid: "web",
+			"1f0e8d0c6b4a2f0e8d6c4b2a1f9e7d5c3b1a0f8e6d4c2b0a8f6e5d3b1a4f9e2c": "log-shipper",
+			"c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5": "node-exporter",
+			"e1f2a3b4c5d6e7f8a9b0c1d2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1f2": "",
+			"a0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1": "batch",
+		})
+		So(qos, ShouldResemble, map[string]string{
+			"frontend":    "burstable",
+			"kube-system": "burstable",
+			"jobs":        "besteffort",
+		})
+	})
+}
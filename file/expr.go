@@ -5,35 +5,101 @@ import (
 	"fmt"
 	"errors"
 	"math"
+	"regexp"
+	"sync"
 )
 
+// Operator that pops a fixed number of arguments from the stack and pushes
+// the result. Comparisons and logical operators push 1 for true and 0 for
+// false.
+type operator struct {
+	arity int
+	apply func(args []interface{}) (interface{}, error)
+}
+
+// Operators supported in expressions.
+var operators = map[string]operator{
+	":add": numericOp(add),
+	":sub": numericOp(sub),
+	":mul": numericOp(mul),
+	":div": numericOp(div),
+
+	":lt": numericOp(lt),
+	":le": numericOp(le),
+	":gt": numericOp(gt),
+	":ge": numericOp(ge),
+	":eq": {2, eq},
+	":ne": {2, ne},
+	":re": {2, re},
+
+	":and": {2, and},
+	":or":  {2, or},
+	":not": {1, not},
+}
+
+var arityNames = map[int]string{
+	1: "one argument",
+	2: "two arguments",
+}
+
+// Split an expression into its parts. A part in single quotes is a literal
+// string that can contain ',' and ':' and is not parsed as a number or
+// variable, e.g. '^:8080$' or 'a{1,3}'. Use '' for a quote inside a literal.
+func exprParts(expr string) ([]string, error) {
+	parts := []string{}
+	for _, part := range strings.Split(expr, ",") {
+		n := len(parts)
+		if n > 0 && isOpenLiteral(parts[n - 1]) {
+			parts[n - 1] = parts[n - 1] + "," + part
+		} else {
+			parts = append(parts, part)
+		}
+	}
+	if n := len(parts); n > 0 && isOpenLiteral(parts[n - 1]) {
+		return nil, errors.New(fmt.Sprintf("unterminated literal: %v", strings.Trim(parts[n - 1], " \t\r\n")))
+	}
+	return parts, nil
+}
+
+// Check if a part starts a quoted literal that has not been closed yet.
+func isOpenLiteral(part string) bool {
+	p := strings.Trim(part, " \t\r\n")
+	if len(p) == 0 || p[0] != '\'' {
+		return false
+	}
+	quotes := len(p) - len(strings.TrimRight(p, "'"))
+	if quotes == len(p) {
+		return quotes % 2 == 1
+	}
+	return quotes % 2 == 0
+}
+
+// Get the value of a quoted literal part.
+func literalValue(part string) (string, bool) {
+	p := strings.Trim(part, " \t\r\n")
+	plen := len(p)
+	if plen < 2 || p[0] != '\'' || p[plen - 1] != '\'' {
+		return "", false
+	}
+	return strings.Replace(p[1:plen - 1], "''", "'", -1), true
+}
+
 func eval(vars map[string]interface{}, expr string) (interface{}, error) {
 	var err error
 	stack := []interface{}{}
-	parts := strings.Split(expr, ",")
+	parts, err := exprParts(expr)
+	if err != nil {
+		return nil, err
+	}
 	for _, part := range parts {
-		switch strings.Trim(part, " \t\r\n") {
-		case ":add":
-			stack, err = binaryOp(stack, add)
-			if err != nil {
-				return nil, err
-			}
-		case ":sub":
-			stack, err = binaryOp(stack, sub)
+		if literal, ok := literalValue(part); ok {
+			stack = append(stack, literal)
+		} else if op, ok := operators[strings.Trim(part, " \t\r\n")]; ok {
+			stack, err = applyOp(stack, op)
 			if err != nil {
 				return nil, err
 			}
-		case ":mul":
-			stack, err = binaryOp(stack, mul)
-			if err != nil {
-				return nil, err
-			}
-		case ":div":
-			stack, err = binaryOp(stack, div)
-			if err != nil {
-				return nil, err
-			}
-		default:
+		} else {
 			var v interface{} = part
 			vlen := len(part)
 			if vlen > 2 && part[0] == '{' && part[vlen - 1] == '}' {
@@ -58,6 +124,42 @@ func eval(vars map[string]interface{}, expr string) (interface{}, error) {
 	return stack[0], nil
 }
 
+// Check the syntax of an expression without evaluating it and return the
+// names of the variables that are referenced.
+func checkExpr(expr string) ([]string, error) {
+	vars := []string{}
+	depth := 0
+	parts, err := exprParts(expr)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range parts {
+		p := strings.Trim(part, " \t\r\n")
+		if _, ok := literalValue(part); ok {
+			depth++
+		} else if op, ok := operators[p]; ok {
+			if depth < op.arity {
+				return nil, errors.New(fmt.Sprintf("operator %v needs at least %s on the stack", p, arityNames[op.arity]))
+			}
+			depth = depth - op.arity + 1
+		} else if len(p) > 0 && p[0] == ':' {
+			return nil, errors.New(fmt.Sprintf("unknown operator: '%v'", p))
+		} else {
+			vlen := len(part)
+			if vlen > 2 && part[0] == '{' && part[vlen - 1] == '}' {
+				vars = append(vars, part[1:vlen - 1])
+			}
+			depth++
+		}
+	}
+
+	if depth != 1 {
+		return nil, errors.New(fmt.Sprintf("stack should have single item, found %d", depth))
+	}
+
+	return vars, nil
+}
+
 func add(v1 float64, v2 float64) float64 {
 	return v1 + v2
 }
@@ -74,23 +176,154 @@ func div(v1 float64, v2 float64) float64 {
 	return v1 / v2
 }
 
-func binaryOp(stack []interface{}, op func(float64,float64)float64) ([]interface{}, error) {
-	end := len(stack)
-	if end < 2 {
-		return nil, errors.New(fmt.Sprintf("need at least two arguments on the stack: %v", stack))
+func boolValue(b bool) float64 {
+	if b {
+		return 1.0
 	}
+	return 0.0
+}
 
-	v2, err := toNumber(stack[end - 1])
+// Check if the result of an expression is true, i.e. a number other than 0.
+func isTrue(v interface{}) (bool, error) {
+	n, err := toNumber(v)
+	if err != nil {
+		return false, err
+	}
+	return n != 0.0 && !math.IsNaN(n), nil
+}
+
+func lt(v1 float64, v2 float64) float64 {
+	return boolValue(v1 < v2)
+}
+
+func le(v1 float64, v2 float64) float64 {
+	return boolValue(v1 <= v2)
+}
+
+func gt(v1 float64, v2 float64) float64 {
+	return boolValue(v1 > v2)
+}
+
+func ge(v1 float64, v2 float64) float64 {
+	return boolValue(v1 >= v2)
+}
+
+// Logical operators use isTrue for the arguments, so NaN is false the same
+// as for filters.
+func logicalArgs(args []interface{}) (bool, bool, error) {
+	b1, err := isTrue(args[0])
+	if err != nil {
+		return false, false, err
+	}
+	b2, err := isTrue(args[1])
+	if err != nil {
+		return false, false, err
+	}
+	return b1, b2, nil
+}
+
+func and(args []interface{}) (interface{}, error) {
+	b1, b2, err := logicalArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return boolValue(b1 && b2), nil
+}
+
+func or(args []interface{}) (interface{}, error) {
+	b1, b2, err := logicalArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return boolValue(b1 || b2), nil
+}
+
+// Values are compared as numbers if both are numbers, otherwise as strings.
+func equals(args []interface{}) bool {
+	n1, err1 := toNumber(args[0])
+	n2, err2 := toNumber(args[1])
+	if err1 == nil && err2 == nil {
+		return n1 == n2
+	}
+	return fmt.Sprintf("%v", args[0]) == fmt.Sprintf("%v", args[1])
+}
+
+func eq(args []interface{}) (interface{}, error) {
+	return boolValue(equals(args)), nil
+}
+
+func ne(args []interface{}) (interface{}, error) {
+	return boolValue(!equals(args)), nil
+}
+
+// Compiled patterns for the :re operator, so they do not need to be compiled
+// for each record.
+var patternCache = struct {
+	sync.Mutex
+	patterns map[string]*regexp.Regexp
+}{patterns: map[string]*regexp.Regexp{}}
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	patternCache.Lock()
+	defer patternCache.Unlock()
+	if re, ok := patternCache.patterns[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
 	if err != nil {
 		return nil, err
 	}
+	patternCache.patterns[pattern] = re
+	return re, nil
+}
+
+// Check if the first argument matches the regular expression. A pattern
+// with a ',' or a leading or trailing ':' needs to be quoted, see exprParts.
+func re(args []interface{}) (interface{}, error) {
+	pattern, err := compilePattern(fmt.Sprintf("%v", args[1]))
+	if err != nil {
+		return nil, err
+	}
+	return boolValue(pattern.MatchString(fmt.Sprintf("%v", args[0]))), nil
+}
 
-	v1, err := toNumber(stack[end - 2])
+func not(args []interface{}) (interface{}, error) {
+	b, err := isTrue(args[0])
 	if err != nil {
 		return nil, err
 	}
+	return boolValue(!b), nil
+}
+
+// Create an operator for a function of two numbers.
+func numericOp(op func(float64, float64) float64) operator {
+	return operator{2, func(args []interface{}) (interface{}, error) {
+		v2, err := toNumber(args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		v1, err := toNumber(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		return op(v1, v2), nil
+	}}
+}
 
-	return append(stack[:end - 2], op(v1, v2)), nil
+func applyOp(stack []interface{}, op operator) ([]interface{}, error) {
+	end := len(stack)
+	if end < op.arity {
+		return nil, errors.New(fmt.Sprintf("need at least %s on the stack: %v", arityNames[op.arity], stack))
+	}
+
+	args := append([]interface{}{}, stack[end - op.arity:]...)
+	v, err := op.apply(args)
+	if err != nil {
+		return nil, err
+	}
+	return append(stack[:end - op.arity], v), nil
 }
 
 func toNumber(v interface{}) (float64, error) {
@@ -16,6 +16,7 @@
 package file
 
 import (
+	"math"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -80,4 +81,109 @@ func TestExprEval(t *testing.T) {
 		So(err.Error(), ShouldResemble, "stack should have single item, found: [foo 4]")
 	})
 
+	Convey("checkExpr", t, func() {
+		vars, err := checkExpr("1.0")
+		So(err, ShouldBeNil)
+		So(vars, ShouldResemble, []string{})
+
+		vars, err = checkExpr("{a},2.0,:add,{b},:mul")
+		So(err, ShouldBeNil)
+		So(vars, ShouldResemble, []string{"a", "b"})
+
+		vars, err = checkExpr("{model name}")
+		So(err, ShouldBeNil)
+		So(vars, ShouldResemble, []string{"model name"})
+
+		_, err = checkExpr("4,:add")
+		So(err.Error(), ShouldResemble, "operator :add needs at least two arguments on the stack")
+
+		_, err = checkExpr("{c},4")
+		So(err.Error(), ShouldResemble, "stack should have single item, found 2")
+
+		_, err = checkExpr("{c},4,:pow")
+		So(err.Error(), ShouldResemble, "unknown operator: ':pow'")
+	})
+
+}
+
+func TestExprPredicates(t *testing.T) {
+
+	Convey("comparison and logical operators", t, func() {
+		vars := map[string]interface{}{
+			"iface": "veth929074f",
+			"bytes": 42.0,
+			"nan":   math.NaN(),
+		}
+
+		check := func(expr string, expected float64) {
+			value, err := eval(vars, expr)
+			So(err, ShouldBeNil)
+			So(value, ShouldResemble, expected)
+		}
+
+		check("{bytes},42,:eq", 1.0)
+		check("{bytes},42.0,:ne", 0.0)
+		check("{bytes},100,:lt", 1.0)
+		check("{bytes},42,:le", 1.0)
+		check("{bytes},42,:gt", 0.0)
+		check("{bytes},42,:ge", 1.0)
+		check("{iface},lo,:eq", 0.0)
+		check("{iface},lo,:ne", 1.0)
+		check("{iface},^veth,:re", 1.0)
+		check("{iface},^veth,:re,:not", 0.0)
+		check("{iface},^veth,:re,{bytes},0,:gt,:and", 1.0)
+		check("{iface},^eth,:re,{bytes},0,:gt,:or", 1.0)
+		check("1,{nan},:and", 0.0)
+		check("{nan},1,:and", 0.0)
+		check("{nan},NaN,:or", 0.0)
+		check("{nan},1,:or", 1.0)
+		check("{nan},:not", 1.0)
+
+		_, err := eval(vars, "{iface},1,:and")
+		So(err.Error(), ShouldEqual, "not a number: 'veth929074f' string")
+
+		_, err = eval(vars, "{iface},(,:re")
+		So(err.Error(), ShouldEqual, "error parsing regexp: missing closing ): `(`")
+
+		_, err = eval(vars, ":not")
+		So(err.Error(), ShouldEqual, "need at least one argument on the stack: []")
+
+		_, err = checkExpr("{iface},lo,:eq,:not,:and")
+		So(err.Error(), ShouldEqual, "operator :and needs at least two arguments on the stack")
+
+		used, err := checkExpr("{iface},lo,:eq,:not")
+		So(err, ShouldBeNil)
+		So(used, ShouldResemble, []string{"iface"})
+	})
+
+	Convey("quoted literals", t, func() {
+		vars := map[string]interface{}{
+			"iface": "veth929074f",
+			"addr":  "0.0.0.0:8080",
+		}
+
+		check := func(expr string, expected interface{}) {
+			value, err := eval(vars, expr)
+			So(err, ShouldBeNil)
+			So(value, ShouldResemble, expected)
+			_, err = checkExpr(expr)
+			So(err, ShouldBeNil)
+		}
+
+		check("{iface},'^veth[0-9a-f]{1,8}$',:re", 1.0)
+		check("{iface},'^veth[0-9a-f]{1,3}$',:re", 0.0)
+		check("{addr},':8080$',:re", 1.0)
+		check("{addr},' :8080 ',:eq", 0.0)
+		check("'{iface}'", "{iface}")
+		check("'42'", "42")
+		check("'it''s'", "it's")
+		check("''", "")
+		check("'a,b,,c'", "a,b,,c")
+
+		_, err := checkExpr("{addr},':8080$,:re")
+		So(err.Error(), ShouldEqual, "unterminated literal: ':8080$,:re")
+
+		_, err = checkExpr("{addr},:8080$,:re")
+		So(err.Error(), ShouldEqual, "unknown operator: ':8080$'")
+	})
 }
@@ -21,7 +21,6 @@ import (
 
 	"github.com/intelsdi-x/snap/control/plugin"
 	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
-	"github.com/intelsdi-x/snap/core/ctypes"
 	"github.com/intelsdi-x/snap-plugin-utilities/config"
 )
 
@@ -35,7 +34,13 @@ var _ plugin.CollectorPlugin = (*fileCollector)(nil)
 
 type fileCollector struct {
 	initialized bool
-	fileConfigs []fileConfig
+	fs          fileSystem
+	loader      *setfileLoader
+	metadata    *metadataCache
+	typeTag     string
+
+	// Errors for individual files from the last collection.
+	fileErrors []fileError
 }
 
 func NewFileCollector() *fileCollector {
@@ -58,59 +63,141 @@ func (f *fileCollector) CollectMetrics(metrics []plugin.MetricType) ([]plugin.Me
 	metricTypes := []plugin.MetricType{}
 
 	if !f.initialized {
-		setfile, err := config.GetConfigItem(metrics[0], "setfile")
-		if err != nil {
+		if err := f.init(metrics[0]); err != nil {
 			return nil, err
 		}
+	}
 
-		fileConfigs, err := fromJsonFile(setfile.(string))
-		if err != nil {
-			return nil, err
-		}
-		f.fileConfigs = *fileConfigs
+	// The setfile is checked for changes on each collection, if it is not
+	// valid then the previous generation will continue to be used.
+	gen, err := f.loader.reload()
+	if err != nil {
+		return nil, err
 	}
 
-	for _, cfg := range f.fileConfigs {
-		mts, err := cfg.collectMetrics(logger, metrics)
+	ctx := newCollectContext(logger, f.fs)
+	ctx.metadata = f.metadata
+	ctx.typeTag = f.typeTag
+	for _, cfg := range gen.configs {
+		mts, err := cfg.collectMetrics(ctx, metrics)
 		handleErr(err)
 		metricTypes = append(metricTypes, mts...)
 	}
+	f.metadata.sweep()
+	f.fileErrors = ctx.errors
+	metricTypes = append(metricTypes, f.loader.metrics(metrics, ctx.start)...)
 
 	return metricTypes, nil
 }
 
 func (f *fileCollector) GetMetricTypes(config plugin.ConfigType) ([]plugin.MetricType, error) {
-	table := config.Table()
-	fileset := table["setfile"].(ctypes.ConfigValueStr).Value
-	log.Infof("loading metrics from setfile: %v", fileset)
-	fileConfigs, err := fromJsonFile(fileset)
-	handleErr(err)
-  f.fileConfigs = *fileConfigs
+	if err := f.init(config); err != nil {
+		return nil, err
+	}
 
-	metricTypes := []plugin.MetricType{}
-	for _, cfg := range *fileConfigs {
-		mts, err := cfg.getMetricTypes()
-		handleErr(err)
-		metricTypes = append(metricTypes, mts...)
+	gen, err := f.loader.reload()
+	if err != nil {
+		return nil, err
 	}
-	log.Infof("configured %v metrics", len(metricTypes))
-	return metricTypes, nil
+	log.Infof("configured %v metrics", len(gen.metricTypes))
+
+	if limits, ok := getCatalogLimits(config); ok {
+		return append(buildCatalog(gen, f.fs, limits), setfileMetricTypes()...), nil
+	}
+	return append(append([]plugin.MetricType{}, gen.metricTypes...), setfileMetricTypes()...), nil
 
 }
 
+// Setup the setfile loader and file system based on the plugin config.
+func (f *fileCollector) init(cfg interface{}) error {
+	setfile, err := config.GetConfigItem(cfg, "setfile")
+	if err != nil {
+		return err
+	}
+	if f.loader == nil || f.loader.location != setfile.(string) {
+		log.Infof("loading metrics from setfile: %v", setfile)
+		f.loader = newSetfileLoader(setfile.(string))
+	}
+
+	fs, err := getFileSystem(cfg)
+	if err != nil {
+		return err
+	}
+	f.fs = fs
+	f.metadata = newMetadataCache()
+	f.typeTag = defaultTypeTag
+	if v, err := config.GetConfigItem(cfg, "type_tag"); err == nil && v.(string) != "" {
+		f.typeTag = v.(string)
+	}
+	f.initialized = true
+	return nil
+}
+
 func (f *fileCollector) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
 
 	r1, err := cpolicy.NewStringRule("setfile", true)
 	handleErr(err)
-	r1.Description = "Main configuration file for the plugin."
+	r1.Description = "Main configuration file for the plugin. Can also be a directory or glob of setfiles."
+
+	r2, err := cpolicy.NewStringRule("root", false)
+	handleErr(err)
+	r2.Description = "Root directory prepended to all file patterns, e.g. /host."
+
+	r3, err := cpolicy.NewStringRule("root_mode", false, rootModePrefix)
+	handleErr(err)
+	r3.Description = "Either 'prefix' to only read files under the root or 'overlay' to fall back to the real path."
+
+	r4, err := cpolicy.NewStringRule("snapshot", false)
+	handleErr(err)
+	r4.Description = "Tar or zip archive of a host file system to replay instead of reading the local files."
+
+	r5, err := cpolicy.NewBoolRule("catalog", false, false)
+	handleErr(err)
+	r5.Description = "Scan the current files to advertise the concrete metrics that are available."
+
+	r6, err := cpolicy.NewIntegerRule("catalog_timeout", false, defaultCatalogTimeout)
+	handleErr(err)
+	r6.Description = "Maximum number of seconds to spend scanning files for the catalog."
+
+	r7, err := cpolicy.NewIntegerRule("catalog_limit", false, defaultCatalogLimit)
+	handleErr(err)
+	r7.Description = "Maximum number of concrete metrics to include in the catalog."
+
+	r8, err := cpolicy.NewStringRule("type_tag", false, defaultTypeTag)
+	handleErr(err)
+	r8.Description = "Tag key used for the type of a metric, e.g. atlas.dstype."
 
 	cp := cpolicy.New()
 	config := cpolicy.NewPolicyNode()
-	config.Add(r1)
+	config.Add(r1, r2, r3, r4, r5, r6, r7, r8)
 	cp.Add([]string{""}, config)
 	return cp, nil
 }
 
+// Get the file system for accessing files based on the plugin config. If a
+// snapshot is configured, then files will be read from the archive instead
+// of the local file system.
+func getFileSystem(cfg interface{}) (fileSystem, error) {
+	var fs fileSystem = osFileSystem{}
+	if v, err := config.GetConfigItem(cfg, "snapshot"); err == nil && v.(string) != "" {
+		log.Infof("loading files from snapshot: %v", v)
+		snapshot, err := loadSnapshot(v.(string))
+		if err != nil {
+			return nil, err
+		}
+		fs = snapshot
+	}
+
+	prefix, mode := "", ""
+	if v, err := config.GetConfigItem(cfg, "root"); err == nil {
+		prefix = v.(string)
+	}
+	if v, err := config.GetConfigItem(cfg, "root_mode"); err == nil {
+		mode = v.(string)
+	}
+	return newFileRoot(fs, prefix, mode)
+}
+
 func handleErr(e error) {
 	if e != nil {
 		panic(e)
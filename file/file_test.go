@@ -18,15 +18,84 @@ package file
 import (
 	"testing"
 
+	"github.com/intelsdi-x/snap/control/plugin"
+	"github.com/intelsdi-x/snap/core/cdata"
+	"github.com/intelsdi-x/snap/core/ctypes"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
+func newTestConfig(items map[string]string) plugin.ConfigType {
+	node := cdata.NewNode()
+	for k, v := range items {
+		node.AddItem(k, ctypes.ConfigValueStr{Value: v})
+	}
+	return plugin.ConfigType{ConfigDataNode: node}
+}
+
 func TestFileCollector(t *testing.T) {
 
-	Convey("substitute", t, func() {
-		//vars := map[string]string{}
-		//So(substitute("no matches {foo}", vars), ShouldResemble, "no matches {foo}")
+	Convey("collect from snapshot", t, func() {
+		cfg := newTestConfig(map[string]string{
+			"setfile":  "testdata/docker.json",
+			"snapshot": "testdata/host.tar.gz",
+		})
+
+		f := NewFileCollector()
+		mts, err := f.GetMetricTypes(cfg)
+		So(err, ShouldBeNil)
+		So(len(mts), ShouldEqual, 13+2)
+
+		for i := range mts {
+			mts[i].Config_ = cfg.ConfigDataNode
+		}
+		ms, err := f.CollectMetrics(mts)
+		So(err, ShouldBeNil)
+		So(len(ms), ShouldEqual, 28+2)
+
+		values := map[string]interface{}{}
+		for _, m := range ms {
+			values[m.Namespace().String()] = m.Data()
+		}
+		container := "3f1a0c8e9b7d6a5f4e3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d4c3b2a10"
+		So(values["/netflix/linux/docker/"+container+"/cpu/shares"], ShouldResemble, 1024.0)
+		So(values["/netflix/linux/docker/"+container+"/cpu/user/usage"], ShouldResemble, 1.5)
+		So(values["/netflix/linux/docker/"+container+"/memory/rss/size"], ShouldResemble, 327680.0)
+		So(values["/file/setfile/generation"], ShouldResemble, 1.0)
+		So(values["/file/setfile/reload_error"], ShouldResemble, 0.0)
+	})
+
+	Convey("advertise concrete metrics in the catalog", t, func() {
+		cfg := newTestConfig(map[string]string{
+			"setfile":  "testdata/docker.json",
+			"snapshot": "testdata/host.tar.gz",
+		})
+		cfg.AddItem("catalog", ctypes.ConfigValueBool{Value: true})
+
+		mts, err := NewFileCollector().GetMetricTypes(cfg)
+		So(err, ShouldBeNil)
+		So(len(mts), ShouldEqual, 13+28+2)
+		So(mts[0].Namespace().String(), ShouldEqual, "/netflix/linux/docker/*/cpu/shares")
+		So(mts[13].Namespace().String(), ShouldEqual, "/netflix/linux/docker/3f1a0c8e9b7d6a5f4e3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d4c3b2a10/cpu/processing_capacity")
+		So(mts[13].Namespace()[3].IsDynamic(), ShouldBeFalse)
+
+		cfg.AddItem("catalog_limit", ctypes.ConfigValueInt{Value: 5})
+		mts, err = NewFileCollector().GetMetricTypes(cfg)
+		So(err, ShouldBeNil)
+		So(len(mts), ShouldEqual, 13+5+2)
+
+		cfg.AddItem("catalog_timeout", ctypes.ConfigValueInt{Value: 0})
+		mts, err = NewFileCollector().GetMetricTypes(cfg)
+		So(err, ShouldBeNil)
+		So(len(mts), ShouldEqual, 13+2)
+	})
+
+	Convey("reject setfile with namespace collision", t, func() {
+		cfg := newTestConfig(map[string]string{
+			"setfile": "testdata/collision.json",
+		})
 
+		_, err := NewFileCollector().GetMetricTypes(cfg)
+		So(err.Error(), ShouldEqual, "namespace collision: testdata/collision.json:12 entry 1 metric '/test/load/avg01m' has the same namespace as testdata/collision.json:2 entry 0 metric '/test/load/avg01m'")
 	})
 
 }
@@ -2,14 +2,13 @@ package file
 
 import (
 	"encoding/json"
-	"io/ioutil"
 	"github.com/intelsdi-x/snap/control/plugin"
 	"fmt"
 	"github.com/intelsdi-x/snap/core"
 	"strings"
 	"errors"
 	"os"
-	"path/filepath"
+	"regexp"
 	"runtime"
 	"strconv"
 	"time"
@@ -19,26 +18,79 @@ import (
 type fileConfig struct {
 	File    string                  `json:"file"`
 
-	Metrics map[string]string    `json:"metrics"`
+	Metrics map[string]metricConfig `json:"metrics"`
 
 	Tags map[string]string          `json:"tags"`
 
 	Parser  parserConfig          `json:"parser"`
+
+	// Defaults for the metrics that do not specify a unit or description.
+	Unit        string            `json:"unit"`
+	Description string            `json:"description"`
+
+	// Inputs to join instead of reading a single file, see inputConfig. The
+	// join variables must be available for the records of all inputs.
+	Inputs map[string]inputConfig `json:"inputs"`
+	Join []string                 `json:"join"`
+	Missing string                `json:"missing"`
+
+	// Only collect the files for processes that match, see processConfig.
+	Process *processConfig        `json:"process"`
+
+	// Lookup metadata such as the container name for each record, see
+	// enrichConfig. The variables are available to the filter and metrics.
+	Enrich []enrichConfig         `json:"enrich"`
+
+	// Expression evaluated for each record, only records where the result is
+	// true, i.e. not 0, will be used. For example '{iface},lo,:ne'.
+	Filter string                 `json:"filter"`
+
+	// How to determine the timestamp for the metrics, defaults to the start
+	// time of the collection.
+	Timestamp timestampConfig     `json:"timestamp"`
+
+	// If greater than 0, files that have not been modified for more than
+	// this number of seconds will be skipped.
+	MaxAge uint32                 `json:"max_age"`
+
+	// Rules applied in order to the dynamic namespace elements and tags of
+	// the metrics after they have been created, see relabelRule.
+	Relabel []relabelRule         `json:"relabel"`
+
+	// Name of a template from the setfile to use for fields that are not
+	// set on this config.
+	Template string               `json:"template"`
+
+	// Description of where the config was loaded from used for error
+	// messages, e.g. "setfile.json entry 3".
+	source string
 }
 
 func fromJson(data []byte) (*[]fileConfig, error) {
-	value := &[]fileConfig{}
-	err := json.Unmarshal(data, value)
-	return value, err
+	return fromJsonWith(data, nil)
 }
 
-func fromJsonFile(file string) (*[]fileConfig, error) {
-	data, err := ioutil.ReadFile(file)
-	if err != nil {
+// Same as fromJson, but if shared is set, then the named parsers and
+// templates are looked up in it instead of the document itself.
+func fromJsonWith(data []byte, shared *setfileDoc) (*[]fileConfig, error) {
+	doc := setfileDoc{}
+	if err := json.Unmarshal(data, &doc); err != nil {
 		return nil, err
-	} else {
-		return fromJson(data)
 	}
+	if shared != nil {
+		doc.Parsers = shared.Parsers
+		doc.Templates = shared.Templates
+	}
+
+	value := &[]fileConfig{}
+	for i, entry := range doc.Files {
+		c, err := doc.resolve(entry)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("entry %d: %v", i, err))
+		}
+		*value = append(*value, c)
+	}
+	return value, nil
 }
 
 func isSubstitution(part string) bool {
@@ -79,7 +131,12 @@ func toNamespace(pattern string) (*core.Namespace, error) {
 		return nil, errors.New(msg)
 	}
 
-	if isSubstitution(parts[numParts - 1]) {
+	// The wildcard key is allowed at the end since metrics are created for
+	// each key, e.g. '/linux/memory/{__key__}'. Snap does not allow the last
+	// element to be dynamic when advertised, so the keys must be declared,
+	// see metricConfig.validate.
+	last := parts[numParts - 1]
+	if isSubstitution(last) && last != "{" + keyVar + "}" {
 		msg := fmt.Sprintf("namespace pattern must end with static element: '%v'", pattern)
 		return nil, errors.New(msg)
 	}
@@ -97,15 +154,170 @@ func toNamespace(pattern string) (*core.Namespace, error) {
 	return &ns, nil
 }
 
+// Normalized form of a namespace pattern where all dynamic elements are
+// replaced with '*'. Two patterns with the same normalized form will match
+// the same metrics.
+func namespaceKey(pattern string) string {
+	ns, err := toNamespace(pattern)
+	if err != nil {
+		return pattern
+	}
+	parts := []string{}
+	for _, e := range *ns {
+		if e.IsDynamic() {
+			parts = append(parts, "*")
+		} else {
+			parts = append(parts, e.Value)
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+// Metric with a namespace pattern that collides with one that was defined
+// earlier. The indices refer to the list of file configs.
+type namespaceCollision struct {
+	index      int
+	metric     string
+	prevIndex  int
+	prevMetric string
+}
+
+func (c namespaceCollision) describe(configs []fileConfig) string {
+	return fmt.Sprintf("%s metric '%s' has the same namespace as %s metric '%s'",
+		configs[c.index].source, c.metric, configs[c.prevIndex].source, c.prevMetric)
+}
+
+// Find metrics that have the same namespace pattern as a metric that was
+// defined earlier in the list of file configs.
+func namespaceCollisions(configs []fileConfig) []namespaceCollision {
+	type metricRef struct {
+		index  int
+		metric string
+	}
+
+	collisions := []namespaceCollision{}
+	seen := map[string]metricRef{}
+	for i, c := range configs {
+		for _, k := range sortedKeys(c.Metrics) {
+			key := namespaceKey(k)
+			if prev, ok := seen[key]; ok {
+				collisions = append(collisions, namespaceCollision{i, k, prev.index, prev.metric})
+			} else {
+				seen[key] = metricRef{i, k}
+			}
+		}
+	}
+	return collisions
+}
+
+// Check that no two metrics in the file configs have the same namespace
+// pattern and that they cannot match the metrics reported by the plugin
+// about the setfile. Otherwise different values would be reported for the
+// same metric.
+func checkNamespaceCollisions(configs []fileConfig) error {
+	msgs := []string{}
+	for _, c := range namespaceCollisions(configs) {
+		msgs = append(msgs, c.describe(configs))
+	}
+	for _, c := range configs {
+		for _, k := range sortedKeys(c.Metrics) {
+			if ns, ok := reservedNamespace(k); ok {
+				msgs = append(msgs, fmt.Sprintf("%s metric '%s' has the same namespace as the plugin metric '%s'", c.source, k, ns))
+			}
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return errors.New("namespace collision: " + strings.Join(msgs, "; "))
+}
+
+// Add the source of the config to an error message.
+func (c fileConfig) sourceError(err error) error {
+	if c.source == "" {
+		return err
+	}
+	return errors.New(fmt.Sprintf("%s: %v", c.source, err))
+}
+
 func (c fileConfig) getMetricTypes() ([]plugin.MetricType, error) {
 
+	if len(c.Inputs) > 0 {
+		if err := c.validateJoin(); err != nil {
+			return nil, err
+		}
+	} else if _, err := newFilePattern(c.File); err != nil {
+		return nil, err
+	}
+
+	if err := validateUnit(c.Unit); err != nil {
+		return nil, err
+	}
+	if err := c.Timestamp.validate(); err != nil {
+		return nil, err
+	}
+	if c.Filter != "" {
+		if _, err := checkExpr(c.Filter); err != nil {
+			return nil, errors.New(fmt.Sprintf("filter: %v", err))
+		}
+	}
+	if c.Process != nil {
+		if err := c.Process.validate(); err != nil {
+			return nil, errors.New(fmt.Sprintf("process: %v", err))
+		}
+		if len(c.Inputs) == 0 {
+			if err := c.Process.validatePattern(c.File); err != nil {
+				return nil, err
+			}
+		}
+		for _, name := range sortedKeys(c.Inputs) {
+			if err := c.Process.validatePattern(c.Inputs[name].File); err != nil {
+				return nil, errors.New(fmt.Sprintf("input '%v': %v", name, err))
+			}
+		}
+	}
+	for i, e := range c.Enrich {
+		if err := e.validate(); err != nil {
+			return nil, errors.New(fmt.Sprintf("enrich[%d]: %v", i, err))
+		}
+	}
+	for i, r := range c.Relabel {
+		if err := r.validate(); err != nil {
+			return nil, errors.New(fmt.Sprintf("relabel[%d]: %v", i, err))
+		}
+	}
+
 	ms := []plugin.MetricType{}
-	for k, _ := range c.Metrics {
+	for k, v := range c.Metrics {
 		ns, err := toNamespace(k)
 		if err != nil {
 			return nil, err
 		}
-		ms = append(ms, plugin.MetricType{Namespace_: *ns})
+		v = v.inherit(c)
+		if err := v.validate(k); err != nil {
+			return nil, errors.New(fmt.Sprintf("metric '%v': %v", k, err))
+		}
+
+		// Wildcard metrics with a declared list of keys are advertised
+		// with the concrete namespaces.
+		namespaces := []core.Namespace{*ns}
+		if isWildcard(k) && len(v.Keys) > 0 {
+			filter, _ := newKeyFilter(v.Include, v.Exclude)
+			namespaces = []core.Namespace{}
+			for _, key := range v.Keys {
+				if filter.matches(key) {
+					namespaces = append(namespaces, expandNamespace(*ns, key))
+				}
+			}
+		}
+
+		for _, n := range namespaces {
+			ms = append(ms, plugin.MetricType{
+				Namespace_:   n,
+				Unit_:        v.Unit,
+				Description_: v.Description,
+			})
+		}
 	}
 
 	return ms, nil
@@ -132,37 +344,68 @@ func defaultVars() map[string]interface{} {
 	return vars
 }
 
-func createMetric(file string, vars map[string]interface{}, ns core.Namespace, valueExpr string) (*plugin.MetricType, error) {
-	for i := 0; i < len(ns); i++ {
-		if ns[i].IsDynamic() {
-			parts := strings.Split(ns[i].Description, ":")
-			if len(parts) == 3 && parts[1] == "path" {
-				idx, err := strconv.Atoi(parts[2])
-				if err != nil {
-					return nil, err
-				}
+// Resolve the value for a substitution such as '{name}' or '{name:path:N}'.
+// The path form selects a segment of the file path, other substitutions are
+// looked up in the variables for the record.
+func resolveVariable(file string, vars map[string]interface{}, subst string) (string, error) {
+	parts := strings.Split(subst, ":")
+	if len(parts) == 3 && parts[1] == "path" {
+		idx, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return "", err
+		}
 
-				path := strings.Split(file, "/")
-				pos := idx
-				if pos < 0 {
-					pos = len(path) + pos
-				}
+		path := strings.Split(file, "/")
+		pos := idx
+		if pos < 0 {
+			pos = len(path) + pos
+		}
 
-				if pos < 0 || pos >= len(path) {
-					msg := fmt.Sprintf("index '%d' out of bounds: %v", idx, path)
-					return nil, errors.New(msg)
-				}
+		if pos < 0 || pos >= len(path) {
+			msg := fmt.Sprintf("index '%d' out of bounds: %v", idx, path)
+			return "", errors.New(msg)
+		}
 
-				ns[i].Value = path[pos]
-			} else {
-				if value, ok := vars[ns[i].Name]; ok {
-					ns[i].Value = fmt.Sprintf("%v", value)
-				} else {
-					msg := fmt.Sprintf("no value for dynamic element '%v'", ns[i].Name)
-					return nil, errors.New(msg)
-				}
-			}
+		return path[pos], nil
+	}
+
+	if value, ok := vars[parts[0]]; ok {
+		return fmt.Sprintf("%v", value), nil
+	}
+	msg := fmt.Sprintf("no value for dynamic element '%v'", parts[0])
+	return "", errors.New(msg)
+}
+
+var substitutionPattern = regexp.MustCompile("\\{[^{}]+\\}")
 
+// Replace substitutions in the string with the values for the record. If
+// there is no value for a substitution it will be left as is.
+func substitute(file string, vars map[string]interface{}, str string) string {
+	return substitutionPattern.ReplaceAllStringFunc(str, func(s string) string {
+		value, err := resolveVariable(file, vars, s[1:len(s) - 1])
+		if err != nil {
+			return s
+		}
+		return value
+	})
+}
+
+func substituteTags(file string, vars map[string]interface{}, tags map[string]string) map[string]string {
+	result := map[string]string{}
+	for k, v := range tags {
+		result[k] = substitute(file, vars, v)
+	}
+	return result
+}
+
+func createMetric(file string, vars map[string]interface{}, ns core.Namespace, valueExpr string, timestamp time.Time) (*plugin.MetricType, error) {
+	for i := 0; i < len(ns); i++ {
+		if ns[i].IsDynamic() {
+			value, err := resolveVariable(file, vars, ns[i].Description)
+			if err != nil {
+				return nil, err
+			}
+			ns[i].Value = value
 		}
 	}
 
@@ -177,7 +420,7 @@ func createMetric(file string, vars map[string]interface{}, ns core.Namespace, v
 
 	m := plugin.NewMetricType(
 		ns,
-		time.Now(),
+		timestamp,
 		map[string]string{},
 		"",
 		value,
@@ -185,37 +428,286 @@ func createMetric(file string, vars map[string]interface{}, ns core.Namespace, v
 	return m, nil
 }
 
-func (c fileConfig) collectMetrics(logger *log.Logger, queries []plugin.MetricType) ([]plugin.MetricType, error) {
+// Error for a particular file encountered during collection.
+type fileError struct {
+	File string
+	Err  error
+}
+
+func (e fileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.File, e.Err)
+}
+
+// State shared by all of the file configs during a single collection.
+type collectContext struct {
+	logger *log.Logger
+	fs     fileSystem
+
+	// Errors for individual files. These do not fail the collection so that
+	// a single bad or missing file will not prevent other metrics from being
+	// reported.
+	errors []fileError
+
+	// Source for each namespace that has been collected. Used to detect
+	// collisions after the dynamic elements have been resolved.
+	namespaces map[string]string
+
+	// If set, files will not be read after the deadline. Used to bound
+	// the time for scanning files when building the catalog.
+	deadline time.Time
+
+	// Start time of the collection. Used as the timestamp for the metrics
+	// unless the file config specifies another source.
+	start time.Time
+
+	// Metadata used for enriching records. Shared across collections so
+	// the state files only need to be parsed when they change.
+	metadata *metadataCache
+
+	// Tag key used for the type of a metric, see metricConfig.Type.
+	typeTag string
+}
+
+func newCollectContext(logger *log.Logger, fs fileSystem) *collectContext {
+	return &collectContext{
+		logger:     logger,
+		fs:         fs,
+		errors:     []fileError{},
+		namespaces: map[string]string{},
+		start:      time.Now(),
+		metadata:   newMetadataCache(),
+		typeTag:    defaultTypeTag,
+	}
+}
+
+func (ctx *collectContext) expired() bool {
+	return !ctx.deadline.IsZero() && time.Now().After(ctx.deadline)
+}
+
+func (ctx *collectContext) fileError(file string, err error) {
+	ctx.logger.Warnf("failed to collect %s: %v", file, err)
+	ctx.errors = append(ctx.errors, fileError{file, err})
+}
+
+// Record the source of a metric and check that the namespace has not already
+// been collected in this collection.
+func (ctx *collectContext) checkCollision(file string, ns string, source string) bool {
+	if prev, ok := ctx.namespaces[ns]; ok {
+		msg := fmt.Sprintf("namespace collision for %s: %s has the same namespace as %s", ns, source, prev)
+		ctx.fileError(file, errors.New(msg))
+		return false
+	}
+	ctx.namespaces[ns] = source
+	return true
+}
+
+func (c fileConfig) collectMetrics(ctx *collectContext, queries []plugin.MetricType) ([]plugin.MetricType, error) {
+	if len(c.Inputs) > 0 {
+		return c.collectJoined(ctx)
+	}
+
 	data := []plugin.MetricType{}
-	files, err := filepath.Glob(c.File)
+	pattern, err := newFilePattern(c.File)
+	if err != nil {
+		return nil, err
+	}
+	files, err := c.loadFiles(ctx, pattern, c.Parser)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		stats := filterStats{}
+		for _, record := range f.records {
+			vars := recordVars(f.captures, record)
+			data = c.appendRecord(ctx, data, f.file, f.mtime, vars, record, &stats)
+		}
+		stats.log(ctx, len(f.records), f.file)
+	}
+
+	return data, nil
+}
+
+// Records parsed from a single file.
+type fileRecords struct {
+	file     string
+	captures map[string]interface{}
+	mtime    time.Time
+	records  []map[string]interface{}
+}
+
+// Find the files matching the pattern and parse them. Errors for individual
+// files will be recorded on the context and those files will be skipped.
+func (c fileConfig) loadFiles(ctx *collectContext, pattern *filePattern, parserCfg parserConfig) ([]fileRecords, error) {
+	logger := ctx.logger
+	files, err := ctx.fs.glob(pattern.glob)
 	if err != nil {
 		return nil, err
 	}
-	logger.Debugf("loading %v files matching pattern '%s'", len(files), c.File)
+	logger.Debugf("loading %v files matching pattern '%s'", len(files), pattern.pattern)
 
+	var processes *processMatcher
+	if c.Process != nil {
+		processes = newProcessMatcher(*c.Process, ctx.fs)
+	}
+
+	result := []fileRecords{}
 	for _, file := range files {
-		logger.Debugf("loading file %s, %v", file, c.Parser)
-		parser := newParser(c.Parser)
-		records, err := parser.parseFile(file)
+		if ctx.expired() {
+			logger.Warnf("deadline exceeded, skipping remaining files matching pattern '%s'", pattern.pattern)
+			break
+		}
+		captures, ok := pattern.match(file)
+		if !ok {
+			logger.Debugf("skipping file %s, does not match pattern '%s'", file, pattern.pattern)
+			continue
+		}
+		if processes != nil {
+			if captures, ok = processes.match(captures); !ok {
+				continue
+			}
+		}
+
+		var mtime time.Time
+		if c.MaxAge > 0 || c.Timestamp.source() == timestampMtime {
+			info, err := ctx.fs.stat(file)
+			if err != nil {
+				ctx.fileError(file, c.sourceError(err))
+				continue
+			}
+			mtime = info.ModTime()
+		}
+		if c.MaxAge > 0 && ctx.start.Sub(mtime) > time.Duration(c.MaxAge)*time.Second {
+			logger.Debugf("skipping file %s, last modified at %v is older than %ds", file, mtime, c.MaxAge)
+			continue
+		}
+
+		logger.Debugf("loading file %s, %v", file, parserCfg)
+		parser := newParser(parserCfg)
+		records, err := parser.parseFile(ctx.fs, file)
 		if err != nil {
-			return nil, err
+			ctx.fileError(file, c.sourceError(err))
+			continue
 		}
 		logger.Debugf("found %d records in %s", len(records), file)
+		result = append(result, fileRecords{file, captures, mtime, records})
+	}
+	if c.Process != nil && c.Process.Aggregate {
+		result = aggregateProcesses(result, c.Process.sumFields())
+	}
+	return result, nil
+}
 
-		for _, record := range records {
-			for k, v := range c.Metrics {
-				logger.Debugf("creating metric %v", k)
-				ns, _ := toNamespace(k)
-				m, err := createMetric(file, record, *ns, v)
-				if err != nil {
-					return nil, err
-				}
-				m.Tags_ = c.Tags
-				logger.Debugf("created metric %s with tags %v", m.Namespace().String(), m.Tags())
-				data = append(data, *m)
+// Number of records and metrics that were dropped by filters.
+type filterStats struct {
+	records int
+	metrics int
+}
+
+func (s filterStats) log(ctx *collectContext, total int, file string) {
+	if s.records > 0 || s.metrics > 0 {
+		ctx.logger.Debugf("filtered %d of %d records and %d metrics from %s",
+			s.records, total, s.metrics, file)
+	}
+}
+
+// Create the metrics for a single record and append them to the list. The
+// vars are all variables for the record, the record is only the values that
+// were parsed and is used for expanding wildcard metrics.
+func (c fileConfig) appendRecord(ctx *collectContext, data []plugin.MetricType, file string, mtime time.Time, vars map[string]interface{}, record map[string]interface{}, stats *filterStats) []plugin.MetricType {
+	vars, ok := c.enrich(ctx, file, vars)
+	if !ok || !c.matches(ctx, file, c.Filter, vars) {
+		stats.records++
+		return data
+	}
+	timestamp, err := c.Timestamp.timestamp(ctx.start, mtime, vars)
+	if err != nil {
+		ctx.fileError(file, c.sourceError(err))
+		return data
+	}
+	for k, v := range c.Metrics {
+		if !isWildcard(k) {
+			if !c.matches(ctx, file, v.Filter, vars) {
+				stats.metrics++
+				continue
 			}
+			data = c.appendMetric(ctx, data, file, vars, timestamp, k, v)
+			continue
+		}
+		filter, err := newKeyFilter(v.Include, v.Exclude)
+		if err != nil {
+			ctx.fileError(file, c.sourceError(err))
+			continue
+		}
+		for _, key := range wildcardKeys(record, filter) {
+			keyVars := wildcardVars(vars, key)
+			if !c.matches(ctx, file, v.Filter, keyVars) {
+				stats.metrics++
+				continue
+			}
+			data = c.appendMetric(ctx, data, file, keyVars, timestamp, k, v)
 		}
 	}
+	return data
+}
 
-	return data, nil
+// Check if the variables for a record match a filter expression. An empty
+// filter matches all records. If the filter cannot be evaluated, then the
+// error will be recorded and the record will not be used.
+func (c fileConfig) matches(ctx *collectContext, file string, filter string, vars map[string]interface{}) bool {
+	if filter == "" {
+		return true
+	}
+	vs := defaultVars()
+	for k, v := range vars {
+		vs[k] = v
+	}
+	result, err := eval(vs, filter)
+	if err == nil {
+		var ok bool
+		if ok, err = isTrue(result); err == nil {
+			return ok
+		}
+	}
+	ctx.fileError(file, c.sourceError(errors.New(fmt.Sprintf("filter: %v", err))))
+	return false
+}
+
+// Create a metric for a record and append it to the list. Errors will be
+// recorded on the context and the list will be returned unchanged.
+func (c fileConfig) appendMetric(ctx *collectContext, data []plugin.MetricType, file string, vars map[string]interface{}, timestamp time.Time, k string, v metricConfig) []plugin.MetricType {
+	ctx.logger.Debugf("creating metric %v", k)
+	v = v.inherit(c)
+	ns, _ := toNamespace(k)
+	m, err := createMetric(file, vars, *ns, v.expr(k), timestamp)
+	if err != nil {
+		ctx.fileError(file, c.sourceError(err))
+		return data
+	}
+	m.Tags_ = substituteTags(file, vars, v.tags(c.Tags, ctx.typeTag))
+	if !relabel(c.Relabel, m) {
+		ctx.logger.Debugf("dropping metric %s for %s", k, file)
+		return data
+	}
+	source := fmt.Sprintf("%s metric '%s' for %s", c.source, k, file)
+	if !ctx.checkCollision(file, m.Namespace().String(), source) {
+		return data
+	}
+	m.Unit_ = v.Unit
+	m.Description_ = v.Description
+	ctx.logger.Debugf("created metric %s with tags %v", m.Namespace().String(), m.Tags())
+	return append(data, *m)
+}
+
+// Combine the captures from the file path with the values parsed from the
+// file. If there is a name conflict the value from the file will win.
+func recordVars(captures map[string]interface{}, record map[string]interface{}) map[string]interface{} {
+	vars := map[string]interface{}{}
+	for k, v := range captures {
+		vars[k] = v
+	}
+	for k, v := range record {
+		vars[k] = v
+	}
+	return vars
 }
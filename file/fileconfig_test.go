@@ -18,35 +18,293 @@ package file
 import (
 	"testing"
 
-	. "github.com/smartystreets/goconvey/convey"
 	"fmt"
+
+	. "github.com/smartystreets/goconvey/convey"
+	log "github.com/Sirupsen/logrus"
+	"github.com/intelsdi-x/snap/control/plugin"
 )
 
 func TestFileConfig(t *testing.T) {
 
+	Convey("substitute", t, func() {
+		vars := map[string]interface{}{
+			"key":   "rss",
+			"value": 42.0,
+		}
+		file := "/sys/fs/cgroup/memory/docker/abc/memory.stat"
+
+		So(substitute(file, vars, "no matches {foo}"), ShouldEqual, "no matches {foo}")
+		So(substitute(file, vars, "{key}"), ShouldEqual, "rss")
+		So(substitute(file, vars, "{key}-{value}"), ShouldEqual, "rss-42")
+		So(substitute(file, vars, "{container:path:-2}"), ShouldEqual, "abc")
+		So(substitute(file, vars, "{container:path:-20}"), ShouldEqual, "{container:path:-20}")
+	})
+
+	Convey("collect with named captures", t, func() {
+		c := fileConfig{
+			File: "testdata/cgroup/cpu/{container:[0-9]}/cpu.shares",
+			Metrics: map[string]metricConfig{
+				"/test/docker/{container}/cpu_shares": newMetricConfig("{cpu_shares}"),
+			},
+			Tags: map[string]string{
+				"id": "{container}",
+			},
+			Parser: newTableConfig([]string{"cpu_shares"}, 0),
+		}
+
+		ms, err := c.collectMetrics(newCollectContext(log.New(), osFileSystem{}), nil)
+		So(err, ShouldBeNil)
+		So(len(ms), ShouldEqual, 3)
+		for i, m := range ms {
+			id := fmt.Sprintf("%d", i)
+			So(m.Namespace().String(), ShouldEqual, "/test/docker/" + id + "/cpu_shares")
+			So(m.Tags(), ShouldResemble, map[string]string{"id": id})
+		}
+		So(ms[0].Data(), ShouldResemble, 1024.0)
+	})
+
+	Convey("collect with root prefix", t, func() {
+		c := fileConfig{
+			File: "/cgroup/cpu/*/cpu.shares",
+			Metrics: map[string]metricConfig{
+				"/test/docker/{container:path:-2}/cpu_shares": newMetricConfig("{cpu_shares}"),
+			},
+			Parser: newTableConfig([]string{"cpu_shares"}, 0),
+		}
+
+		fs, _ := newFileRoot(osFileSystem{}, "testdata", "prefix")
+		ms, err := c.collectMetrics(newCollectContext(log.New(), fs), nil)
+		So(err, ShouldBeNil)
+		So(len(ms), ShouldEqual, 3)
+		So(ms[2].Namespace().String(), ShouldEqual, "/test/docker/2/cpu_shares")
+	})
+
+	Convey("checkNamespaceCollisions", t, func() {
+		configs, err := loadSetfile("testdata/docker.json")
+		So(err, ShouldBeNil)
+		So(checkNamespaceCollisions(*configs), ShouldBeNil)
+
+		cs := []fileConfig{
+			{
+				File:    "/proc/loadavg",
+				Metrics: map[string]metricConfig{"/test/load/{id}/avg": newMetricConfig("{1m}")},
+				source:  "a.json entry 0",
+			},
+			{
+				File:    "/proc/loadavg",
+				Metrics: map[string]metricConfig{"/test/load/{pid}/avg": newMetricConfig("{5m}")},
+				source:  "a.json entry 1",
+			},
+		}
+		err = checkNamespaceCollisions(cs)
+		So(err.Error(), ShouldEqual, "namespace collision: a.json entry 1 metric '/test/load/{pid}/avg' has the same namespace as a.json entry 0 metric '/test/load/{id}/avg'")
+	})
+
+	Convey("collect with namespace collision", t, func() {
+		columns := []string{"label", "user"}
+		pattern := "(cpu\\d*)\\s+(\\d+)"
+		cs := []fileConfig{
+			{
+				File:    "testdata/stat",
+				Metrics: map[string]metricConfig{"/test/cpu/{label}/user": newMetricConfig("{user}")},
+				Parser:  newRegexpConfig(columns, pattern),
+				source:  "a.json entry 0",
+			},
+			{
+				File:    "testdata/stat",
+				Metrics: map[string]metricConfig{"/test/cpu/cpu0/user": newMetricConfig("{user}")},
+				Parser:  newRegexpConfig(columns, pattern),
+				source:  "a.json entry 1",
+			},
+		}
+		So(checkNamespaceCollisions(cs), ShouldBeNil)
+
+		ctx := newCollectContext(log.New(), osFileSystem{})
+		ms, err := cs[0].collectMetrics(ctx, nil)
+		So(err, ShouldBeNil)
+		So(len(ms), ShouldEqual, 3)
+
+		ms, err = cs[1].collectMetrics(ctx, nil)
+		So(err, ShouldBeNil)
+		So(len(ms), ShouldEqual, 0)
+
+		// Static namespace is used for each of the 3 records in the file
+		So(len(ctx.errors), ShouldEqual, 3)
+		So(ctx.errors[0].Err.Error(), ShouldEqual, "namespace collision for /test/cpu/cpu0/user: a.json entry 1 metric '/test/cpu/cpu0/user' for testdata/stat has the same namespace as a.json entry 0 metric '/test/cpu/{label}/user' for testdata/stat")
+		So(ctx.errors[1].Err.Error(), ShouldEqual, "namespace collision for /test/cpu/cpu0/user: a.json entry 1 metric '/test/cpu/cpu0/user' for testdata/stat has the same namespace as a.json entry 0 metric '/test/cpu/{label}/user' for testdata/stat")
+	})
+
 	Convey("fromJson", t, func() {
-		// TODO
-		configs, _ := fromJsonFile("testdata/docker.json")
+		configs, err := fromJson([]byte("[{\"file\": \"a\"}, {\"file\": \"b\"}]"))
+		So(err, ShouldBeNil)
+		So(len(*configs), ShouldEqual, 2)
+		So((*configs)[1].File, ShouldEqual, "b")
 
-		for _, c := range *configs {
-			ms, _ := c.getMetricTypes()
-			for _, m := range ms {
-				fmt.Println(m.Namespace().String())
+		configs, err = fromJson([]byte("{\"files\": [{\"file\": \"a\"}]}"))
+		So(err, ShouldBeNil)
+		So(len(*configs), ShouldEqual, 1)
+
+		_, err = fromJson([]byte("[{\"file\": \"a\"}, {\"file\": 1}]"))
+		So(err.Error(), ShouldEqual, "entry 1: json: cannot unmarshal number into Go struct field fileConfig.file of type string")
+	})
+
+	Convey("per metric config", t, func() {
+		fs, err := loadSnapshot("testdata/host.tar.gz")
+		So(err, ShouldBeNil)
+
+		collect := func(configs []fileConfig) map[string]plugin.MetricType {
+			// The separate entries use the atlas.dstype tag explicitly
+			ctx := newCollectContext(log.New(), fs)
+			ctx.typeTag = "atlas.dstype"
+			result := map[string]plugin.MetricType{}
+			for _, c := range configs {
+				ms, err := c.collectMetrics(ctx, nil)
+				So(err, ShouldBeNil)
+				for _, m := range ms {
+					result[m.Namespace().String()] = m
+				}
+			}
+			So(ctx.errors, ShouldBeEmpty)
+			return result
+		}
+
+		expected, err := loadSetfile("testdata/docker.json")
+		So(err, ShouldBeNil)
+		configs, err := loadSetfile("testdata/docker-memory.json")
+		So(err, ShouldBeNil)
+		So(len(*configs), ShouldEqual, 1)
+
+		// Single entry is equivalent to the separate memory.stat entries
+		expectedMetrics := collect((*expected)[7:])
+		metrics := collect(*configs)
+		So(len(metrics), ShouldEqual, 12)
+		for k, m := range expectedMetrics {
+			So(metrics[k].Tags(), ShouldResemble, m.Tags())
+			So(metrics[k].Data(), ShouldResemble, m.Data())
+		}
+
+		container := "3f1a0c8e9b7d6a5f4e3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d4c3b2a10"
+		So(metrics["/netflix/linux/docker/"+container+"/memory/rss/size"].Unit(), ShouldEqual, "bytes")
+
+		mts, err := (*configs)[0].getMetricTypes()
+		So(err, ShouldBeNil)
+		So(len(mts), ShouldEqual, 6)
+		So(validateSetfile("testdata/docker-memory.json", nil), ShouldBeEmpty)
+	})
+
+	Convey("metricConfig", t, func() {
+		m := metricConfig{}
+		So(m.UnmarshalJSON([]byte("\"{value}\"")), ShouldBeNil)
+		So(m, ShouldResemble, newMetricConfig("{value}"))
+
+		So(m.UnmarshalJSON([]byte("{\"expr\": \"{value}\", \"type\": \"counter\"}")), ShouldBeNil)
+		So(m.tags(map[string]string{"type": "gauge"}, defaultTypeTag), ShouldResemble, map[string]string{"type": "counter"})
+		So(m.tags(map[string]string{"atlas.dstype": "gauge"}, "atlas.dstype"), ShouldResemble, map[string]string{"atlas.dstype": "counter"})
+		So(m.validate("/a"), ShouldBeNil)
+
+		So(metricConfig{Expr: "1", Type: "rate"}.validate("/a").Error(), ShouldEqual, "unknown type: 'rate', expected one of [gauge counter]")
+		So(metricConfig{}.validate("/a").Error(), ShouldEqual, "expr must be specified")
+	})
+
+	Convey("unit and description", t, func() {
+		c := fileConfig{
+			File: "testdata/memory.stat",
+			Metrics: map[string]metricConfig{
+				"/test/memory/pgfault": {Expr: "{pgfault}", Unit: "faults", Description: "Number of page faults."},
+				"/test/memory/rss":     newMetricConfig("{rss}"),
+			},
+			Parser:      newKeyValueConfig("\n\n", " "),
+			Unit:        "bytes",
+			Description: "Memory usage for the cgroup.",
+		}
+
+		mts, err := c.getMetricTypes()
+		So(err, ShouldBeNil)
+		ms, err := c.collectMetrics(newCollectContext(log.New(), osFileSystem{}), nil)
+		So(err, ShouldBeNil)
+
+		for _, m := range append(mts, ms...) {
+			if m.Namespace().String() == "/test/memory/rss" {
+				So(m.Unit(), ShouldEqual, "bytes")
+				So(m.Description(), ShouldEqual, "Memory usage for the cgroup.")
+			} else {
+				So(m.Unit(), ShouldEqual, "faults")
+				So(m.Description(), ShouldEqual, "Number of page faults.")
 			}
-			//fmt.Println(ms)
-			//fmt.Println(err)
+		}
 
-			/*mts, err := c.collectMetrics(nil)
-			fmt.Println(err)
-			for _, m := range mts {
-				fmt.Println(m)
-			}*/
+		c.Unit = "B"
+		_, err = c.getMetricTypes()
+		So(err.Error(), ShouldStartWith, "unknown unit: 'B', expected one of [bits bytes")
+	})
+
+	Convey("validateUnit", t, func() {
+		So(validateUnit(""), ShouldBeNil)
+		So(validateUnit("jiffies"), ShouldBeNil)
+		So(validateUnit("bytes/second"), ShouldBeNil)
+		So(validateUnit("bytes/jiffy"), ShouldNotBeNil)
+		So(validateUnit("byte"), ShouldNotBeNil)
+	})
+
+	Convey("filter records", t, func() {
+		c := fileConfig{
+			File: "testdata/net_dev",
+			Metrics: map[string]metricConfig{
+				"/test/net/{iface}/rx_bytes": newMetricConfig("{rx_bytes}"),
+				"/test/net/{iface}/tx_bytes": {Expr: "{tx_bytes}", Filter: "{tx_bytes},1e9,:gt"},
+			},
+			Parser: newTableConfig([]string{"iface", "rx_bytes", "rx_packets", "rx_errs", "rx_drop", "rx_fifo", "rx_frame", "rx_compressed", "rx_multicast",
+				"tx_bytes", "tx_packets", "tx_errs", "tx_drop", "tx_fifo", "tx_colls", "tx_carrier", "tx_compressed"}, 2),
+			Filter: "{iface},lo,:eq,{iface},^veth,:re,:or,:not",
+		}
+
+		mts, err := c.getMetricTypes()
+		So(err, ShouldBeNil)
+		So(len(mts), ShouldEqual, 2)
+
+		ctx := newCollectContext(log.New(), osFileSystem{})
+		ms, err := c.collectMetrics(ctx, nil)
+		So(err, ShouldBeNil)
+		So(ctx.errors, ShouldBeEmpty)
+
+		ifaces := map[string]bool{}
+		txCount := 0
+		for _, m := range ms {
+			ifaces[m.Namespace()[2].Value] = true
+			if m.Namespace()[3].Value == "tx_bytes" {
+				txCount++
+				So(m.Data().(float64), ShouldBeGreaterThan, 1e9)
+			}
 		}
+		So(ifaces, ShouldResemble, map[string]bool{"eth0": true, "docker0": true, "eth1": true, "eth2": true, "eth3": true})
+		So(txCount, ShouldEqual, 4)
 
-		//fmt.Println(config)
-		//fmt.Println(err)
-		//So(err, ShouldBeNil)
-		//So(value, ShouldResemble, 1.0)
+		c.Filter = "{iface},:not,:and"
+		_, err = c.getMetricTypes()
+		So(err.Error(), ShouldEqual, "filter: operator :and needs at least two arguments on the stack")
+
+		c.Filter = "{missing},1,:eq"
+		ctx = newCollectContext(log.New(), osFileSystem{})
+		ms, err = c.collectMetrics(ctx, nil)
+		So(len(ms), ShouldEqual, 0)
+		So(ctx.errors[0].Err.Error(), ShouldEqual, "filter: unknown variable: 'missing'")
+	})
+
+	Convey("getMetricTypes", t, func() {
+		configs, err := loadSetfile("testdata/docker.json")
+		So(err, ShouldBeNil)
+
+		namespaces := []string{}
+		for _, c := range *configs {
+			ms, err := c.getMetricTypes()
+			So(err, ShouldBeNil)
+			for _, m := range ms {
+				namespaces = append(namespaces, m.Namespace().String())
+			}
+		}
+		So(len(namespaces), ShouldEqual, 13)
+		So(namespaces[3], ShouldEqual, "/netflix/linux/docker/*/cpu/*/usage")
 	})
 
 }
@@ -0,0 +1,334 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Access to the files that will be collected. Normally this is the local
+// file system, but it can also be an in-memory tree such as a snapshot of
+// /proc and /sys captured on another host.
+type fileSystem interface {
+	glob(pattern string) ([]string, error)
+	readFile(name string) ([]byte, error)
+	stat(name string) (os.FileInfo, error)
+	readlink(name string) (string, error)
+}
+
+type osFileSystem struct{}
+
+func (osFileSystem) glob(pattern string) ([]string, error) {
+	return filepath.Glob(pattern)
+}
+
+func (osFileSystem) readFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(name)
+}
+
+func (osFileSystem) stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFileSystem) readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// In-memory file system. Paths are cleaned before use and directories are
+// implied by the files and links they contain. Symbolic links are followed
+// the same way as on a real file system, so snapshots of /sys with links such
+// as /sys/class/net/* can be used.
+type memFileSystem struct {
+	files map[string]memFile
+	links map[string]string
+
+	// Index of the directories and the names of the entries in each
+	// directory. These are updated as files and links are added so that
+	// they do not need to be computed for each lookup.
+	dirs    map[string]bool
+	entries map[string]map[string]bool
+}
+
+func newMemFileSystem() *memFileSystem {
+	return &memFileSystem{
+		files:   map[string]memFile{},
+		links:   map[string]string{},
+		dirs:    map[string]bool{},
+		entries: map[string]map[string]bool{},
+	}
+}
+
+func (m *memFileSystem) add(name string, data []byte, modTime time.Time) {
+	name = path.Clean(name)
+	m.files[name] = memFile{data, modTime}
+	m.index(name)
+}
+
+func (m *memFileSystem) addLink(name string, target string) {
+	name = path.Clean(name)
+	m.links[name] = target
+	m.index(name)
+}
+
+// Add a file or link to the entries of the parent directory, along with
+// any directories that are implied by the path.
+func (m *memFileSystem) index(name string) {
+	for {
+		dir := path.Dir(name)
+		if m.entries[dir] == nil {
+			m.entries[dir] = map[string]bool{}
+		}
+		m.entries[dir][path.Base(name)] = true
+		if dir == "." || dir == "/" || m.dirs[dir] {
+			return
+		}
+		m.dirs[dir] = true
+		name = dir
+	}
+}
+
+// Maximum number of links that will be followed when resolving a path, same
+// as the limit used by Linux.
+const maxLinkDepth = 40
+
+// Resolve the symbolic links in each element of the path so that it can be
+// used to lookup the files and directories. Relative link targets are
+// relative to the directory containing the link, e.g. /sys/class/net/eth0 to
+// ../../devices/virtual/net/eth0.
+func (m *memFileSystem) resolve(op string, name string) (string, error) {
+	resolved := path.Clean(name)
+	for depth := 0; depth <= maxLinkDepth; depth++ {
+		next, ok := m.resolveLink(resolved)
+		if !ok {
+			return resolved, nil
+		}
+		resolved = next
+	}
+	return "", &os.PathError{Op: op, Path: name, Err: syscall.ELOOP}
+}
+
+// Replace the first element of the path that is a link with the target.
+func (m *memFileSystem) resolveLink(name string) (string, bool) {
+	parts := strings.Split(name, "/")
+	for i := 1; i <= len(parts); i++ {
+		p := strings.Join(parts[:i], "/")
+		if target, ok := m.links[p]; ok {
+			if !path.IsAbs(target) {
+				target = path.Join(path.Dir(p), target)
+			}
+			return path.Join(append([]string{target}, parts[i:]...)...), true
+		}
+	}
+	return name, false
+}
+
+// Match the pattern one element at a time so that links to directories are
+// followed. Like filepath.Glob, the matches use the paths with the links
+// rather than the resolved paths.
+func (m *memFileSystem) glob(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	pattern = path.Clean(pattern)
+	matches := []string{"."}
+	if path.IsAbs(pattern) {
+		matches = []string{"/"}
+	}
+	for _, part := range strings.Split(strings.TrimPrefix(pattern, "/"), "/") {
+		next := []string{}
+		for _, dir := range matches {
+			resolved, err := m.resolve("glob", dir)
+			if err != nil {
+				continue
+			}
+			for name := range m.entries[resolved] {
+				if ok, _ := path.Match(part, name); ok {
+					next = append(next, path.Join(dir, name))
+				}
+			}
+		}
+		matches = next
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+func (m *memFileSystem) readFile(name string) ([]byte, error) {
+	resolved, err := m.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := m.files[resolved]
+	if !ok {
+		return nil, notExist("open", name)
+	}
+	return f.data, nil
+}
+
+func (m *memFileSystem) stat(name string) (os.FileInfo, error) {
+	resolved, err := m.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := m.files[resolved]; ok {
+		return memFileInfo{path.Base(name), f.data, f.modTime, false}, nil
+	}
+	if m.dirs[resolved] {
+		return memFileInfo{path.Base(name), nil, time.Time{}, true}, nil
+	}
+	return nil, notExist("stat", name)
+}
+
+// Only the directory is resolved, the last element must be the link.
+func (m *memFileSystem) readlink(name string) (string, error) {
+	name = path.Clean(name)
+	dir, err := m.resolve("readlink", path.Dir(name))
+	if err != nil {
+		return "", err
+	}
+	target, ok := m.links[path.Join(dir, path.Base(name))]
+	if !ok {
+		return "", notExist("readlink", name)
+	}
+	return target, nil
+}
+
+func notExist(op string, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+type memFileInfo struct {
+	name    string
+	data    []byte
+	modTime time.Time
+	dir     bool
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return int64(len(i.data)) }
+func (i memFileInfo) ModTime() time.Time { return i.modTime }
+func (i memFileInfo) IsDir() bool        { return i.dir }
+func (i memFileInfo) Sys() interface{}   { return nil }
+
+func (i memFileInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0555
+	}
+	return 0444
+}
+
+// Load a snapshot of a host file system from a tar or zip archive. Paths in
+// the archive are relative to the root of the host, e.g. proc/loadavg, and
+// will be made absolute. Only regular files are included, along with symbolic
+// links for tar archives.
+func loadSnapshot(file string) (*memFileSystem, error) {
+	switch {
+	case strings.HasSuffix(file, ".zip"):
+		return loadZip(file)
+	case strings.HasSuffix(file, ".tar.gz"), strings.HasSuffix(file, ".tgz"):
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		return loadTar(r)
+	case strings.HasSuffix(file, ".tar"):
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return loadTar(f)
+	default:
+		msg := fmt.Sprintf("unknown snapshot format, expected tar, tar.gz or zip: '%v'", file)
+		return nil, errors.New(msg)
+	}
+}
+
+func loadTar(r io.Reader) (*memFileSystem, error) {
+	fs := newMemFileSystem()
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return fs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Typeflag == tar.TypeSymlink {
+			fs.addLink("/"+h.Name, h.Linkname)
+			continue
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		fs.add("/"+h.Name, data, h.ModTime)
+	}
+}
+
+func loadZip(file string) (*memFileSystem, error) {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	fs := newMemFileSystem()
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		fs.add("/"+f.Name, data, f.Modified)
+	}
+	return fs, nil
+}
@@ -0,0 +1,207 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFileSystem(t *testing.T) {
+
+	Convey("memFileSystem", t, func() {
+		mtime := time.Unix(1470096000, 0)
+		fs := newMemFileSystem()
+		fs.add("/proc/1/stat", []byte("1 (init) S"), mtime)
+		fs.add("/proc/42/stat", []byte("42 (bash) S"), mtime)
+		fs.add("/proc/loadavg", []byte("0.01 0.05 0.05 1/461 13282"), mtime)
+
+		files, err := fs.glob("/proc/*/stat")
+		So(err, ShouldBeNil)
+		So(files, ShouldResemble, []string{"/proc/1/stat", "/proc/42/stat"})
+
+		files, err = fs.glob("/proc/[0-9]*")
+		So(err, ShouldBeNil)
+		So(files, ShouldResemble, []string{"/proc/1", "/proc/42"})
+
+		// Replacing a file does not add another entry
+		fs.add("/proc/1/stat", []byte("1 (systemd) S"), mtime)
+		files, err = fs.glob("/proc/*/stat")
+		So(err, ShouldBeNil)
+		So(files, ShouldResemble, []string{"/proc/1/stat", "/proc/42/stat"})
+
+		files, err = fs.glob("/sys/*")
+		So(err, ShouldBeNil)
+		So(files, ShouldResemble, []string{})
+
+		_, err = fs.glob("/proc/[")
+		So(err, ShouldNotBeNil)
+
+		data, err := fs.readFile("/proc/42/stat")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "42 (bash) S")
+
+		_, err = fs.readFile("/proc/43/stat")
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		info, err := fs.stat("/proc/loadavg")
+		So(err, ShouldBeNil)
+		So(info.Name(), ShouldEqual, "loadavg")
+		So(info.Size(), ShouldEqual, int64(26))
+		So(info.ModTime(), ShouldResemble, mtime)
+		So(info.IsDir(), ShouldBeFalse)
+
+		info, err = fs.stat("/proc/42")
+		So(err, ShouldBeNil)
+		So(info.IsDir(), ShouldBeTrue)
+
+		_, err = fs.stat("/proc/43")
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		fs.addLink("/proc/42/exe", "/bin/bash")
+		target, err := fs.readlink("/proc/42/exe")
+		So(err, ShouldBeNil)
+		So(target, ShouldEqual, "/bin/bash")
+
+		files, err = fs.glob("/proc/42/*")
+		So(err, ShouldBeNil)
+		So(files, ShouldResemble, []string{"/proc/42/exe", "/proc/42/stat"})
+
+		_, err = fs.readlink("/proc/42/stat")
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("loadSnapshot tar.gz", t, func() {
+		fs, err := loadSnapshot("testdata/host.tar.gz")
+		So(err, ShouldBeNil)
+
+		files, err := fs.glob("/sys/fs/cgroup/cpu/docker/*/cpu.shares")
+		So(err, ShouldBeNil)
+		So(len(files), ShouldEqual, 2)
+
+		rows, err := newParser(newTableConfig([]string{"1m", "5m", "15m", "running/total", "last_pid"}, 0)).parseFile(fs, "/proc/loadavg")
+		So(err, ShouldBeNil)
+		So(rows[0]["last_pid"], ShouldResemble, 13282.0)
+
+		info, err := fs.stat("/proc/loadavg")
+		So(err, ShouldBeNil)
+		So(info.ModTime().Unix(), ShouldEqual, int64(1470096000))
+	})
+
+	Convey("memFileSystem links", t, func() {
+		fs := newMemFileSystem()
+		fs.add("/sys/devices/virtual/net/eth0/statistics/rx_bytes", []byte("42"), time.Unix(0, 0))
+		fs.add("/sys/devices/virtual/net/lo/statistics/rx_bytes", []byte("7"), time.Unix(0, 0))
+		fs.addLink("/sys/class/net/eth0", "../../devices/virtual/net/eth0")
+		fs.addLink("/sys/class/net/lo", "/sys/devices/virtual/net/lo")
+		fs.add("/proc/42/stat", []byte("42 (bash) S"), time.Unix(0, 0))
+		fs.addLink("/proc/self", "42")
+		fs.addLink("/loop/a", "b")
+		fs.addLink("/loop/b", "a")
+
+		files, err := fs.glob("/sys/class/net/*/statistics/rx_bytes")
+		So(err, ShouldBeNil)
+		So(files, ShouldResemble, []string{
+			"/sys/class/net/eth0/statistics/rx_bytes",
+			"/sys/class/net/lo/statistics/rx_bytes",
+		})
+
+		data, err := fs.readFile(files[0])
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "42")
+
+		info, err := fs.stat("/sys/class/net/lo")
+		So(err, ShouldBeNil)
+		So(info.Name(), ShouldEqual, "lo")
+		So(info.IsDir(), ShouldBeTrue)
+
+		data, err = fs.readFile("/proc/self/stat")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "42 (bash) S")
+
+		target, err := fs.readlink("/proc/self")
+		So(err, ShouldBeNil)
+		So(target, ShouldEqual, "42")
+
+		_, err = fs.readFile("/loop/a")
+		So(err.Error(), ShouldEqual, "open /loop/a: too many levels of symbolic links")
+
+		files, err = fs.glob("/loop/*/x")
+		So(err, ShouldBeNil)
+		So(files, ShouldResemble, []string{})
+	})
+
+	Convey("loadSnapshot tar with symlinked directory", t, func() {
+		dir, err := ioutil.TempDir("", "snapshot")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		file := filepath.Join(dir, "host.tar")
+		f, err := os.Create(file)
+		So(err, ShouldBeNil)
+		tw := tar.NewWriter(f)
+		data := []byte("12345")
+		So(tw.WriteHeader(&tar.Header{Name: "sys/devices/virtual/block/sda/stat", Mode: 0444, Size: int64(len(data)), Typeflag: tar.TypeReg}), ShouldBeNil)
+		tw.Write(data)
+		So(tw.WriteHeader(&tar.Header{Name: "sys/block/sda", Linkname: "../devices/virtual/block/sda", Typeflag: tar.TypeSymlink}), ShouldBeNil)
+		So(tw.Close(), ShouldBeNil)
+		So(f.Close(), ShouldBeNil)
+
+		fs, err := loadSnapshot(file)
+		So(err, ShouldBeNil)
+		files, err := fs.glob("/sys/block/*/stat")
+		So(err, ShouldBeNil)
+		So(files, ShouldResemble, []string{"/sys/block/sda/stat"})
+
+		rows, err := newParser(newTableConfig([]string{"reads"}, 0)).parseFile(fs, files[0])
+		So(err, ShouldBeNil)
+		So(rows[0]["reads"], ShouldEqual, 12345.0)
+	})
+
+	Convey("loadSnapshot zip", t, func() {
+		dir, err := ioutil.TempDir("", "snapshot")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		file := filepath.Join(dir, "host.zip")
+		f, err := os.Create(file)
+		So(err, ShouldBeNil)
+		zw := zip.NewWriter(f)
+		w, err := zw.Create("proc/loadavg")
+		So(err, ShouldBeNil)
+		w.Write([]byte("0.01 0.05 0.05 1/461 13282"))
+		So(zw.Close(), ShouldBeNil)
+		So(f.Close(), ShouldBeNil)
+
+		fs, err := loadSnapshot(file)
+		So(err, ShouldBeNil)
+		data, err := fs.readFile("/proc/loadavg")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "0.01 0.05 0.05 1/461 13282")
+	})
+
+	Convey("loadSnapshot unknown format", t, func() {
+		_, err := loadSnapshot("testdata/loadavg")
+		So(err.Error(), ShouldEqual, "unknown snapshot format, expected tar, tar.gz or zip: 'testdata/loadavg'")
+	})
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin"
+)
+
+// Input for a file config that joins the records from several files. The
+// variables for the records are prefixed with the name of the input, for
+// example:
+//
+//	"inputs": {
+//	  "usage": {"file": "/sys/fs/cgroup/memory/docker/{container}/memory.usage_in_bytes", "parser": "single-value"},
+//	  "limit": {"file": "/sys/fs/cgroup/memory/docker/{container}/memory.limit_in_bytes", "parser": "single-value"}
+//	},
+//	"join": ["container"],
+//	"metrics": {
+//	  "/linux/docker/{container}/memory/utilization": "{usage.value},{limit.value},:div"
+//	}
+type inputConfig struct {
+	File   string       `json:"file"`
+	Parser parserConfig `json:"parser"`
+}
+
+// Behavior when a join key is not present for all of the inputs.
+const (
+	// Skip the key, the number of skipped keys is logged at debug level.
+	joinMissingSkip = "skip"
+
+	// Skip the key and record an error for the collection.
+	joinMissingError = "error"
+)
+
+var joinMissingModes = []string{joinMissingSkip, joinMissingError}
+
+// Check the settings for a file config that has inputs.
+func (c fileConfig) validateJoin() error {
+	if c.File != "" {
+		return errors.New("file cannot be used with inputs, specify the file for each input")
+	}
+	if len(c.Join) == 0 {
+		return errors.New("join must specify at least one variable when using inputs")
+	}
+	if c.Missing != "" && !contains(joinMissingModes, c.Missing) {
+		msg := fmt.Sprintf("unknown missing mode: '%v', expected one of %v", c.Missing, joinMissingModes)
+		return errors.New(msg)
+	}
+	for _, name := range sortedKeys(c.Inputs) {
+		if _, err := newFilePattern(c.Inputs[name].File); err != nil {
+			return errors.New(fmt.Sprintf("input '%v': %v", name, err))
+		}
+	}
+	return nil
+}
+
+// Key for a record based on the values of the join variables. The second
+// return value is false if one of the variables is missing.
+func joinKey(vars map[string]interface{}, join []string) (string, bool) {
+	values := []string{}
+	for _, j := range join {
+		v, ok := vars[j]
+		if !ok {
+			return "", false
+		}
+		values = append(values, fmt.Sprintf("%v", v))
+	}
+	return strings.Join(values, "/"), true
+}
+
+// Record that is the result of joining the records of the inputs.
+type joinedRecord struct {
+	// File for the first input, used for errors and path substitutions.
+	file string
+
+	// Oldest modification time of the input files.
+	mtime time.Time
+
+	vars   map[string]interface{}
+	inputs []string
+}
+
+// Collect the metrics for a file config with inputs. The records for each
+// input are joined based on the values of the join variables. If there are
+// multiple records for the same key in an input, then the first one is used.
+func (c fileConfig) collectJoined(ctx *collectContext) ([]plugin.MetricType, error) {
+	data := []plugin.MetricType{}
+	names := sortedKeys(c.Inputs)
+
+	keys := []string{}
+	joined := map[string]*joinedRecord{}
+	for _, name := range names {
+		input := c.Inputs[name]
+		pattern, err := newFilePattern(input.File)
+		if err != nil {
+			return nil, err
+		}
+		files, err := c.loadFiles(ctx, pattern, input.Parser)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			for _, record := range f.records {
+				vars := recordVars(f.captures, record)
+				key, ok := joinKey(vars, c.Join)
+				if !ok {
+					msg := fmt.Sprintf("input '%v' does not have join variables %v", name, c.Join)
+					ctx.fileError(f.file, c.sourceError(errors.New(msg)))
+					continue
+				}
+
+				r, ok := joined[key]
+				if !ok {
+					r = &joinedRecord{f.file, f.mtime, map[string]interface{}{}, []string{}}
+					for _, j := range c.Join {
+						r.vars[j] = vars[j]
+					}
+					joined[key] = r
+					keys = append(keys, key)
+				}
+				if contains(r.inputs, name) {
+					ctx.logger.Debugf("ignoring duplicate record for key %s in %s", key, f.file)
+					continue
+				}
+				r.inputs = append(r.inputs, name)
+				if f.mtime.Before(r.mtime) {
+					r.mtime = f.mtime
+				}
+				for k, v := range vars {
+					r.vars[name+"."+k] = v
+				}
+			}
+		}
+	}
+
+	stats := filterStats{}
+	skipped := 0
+	for _, key := range keys {
+		r := joined[key]
+		if len(r.inputs) < len(names) {
+			skipped++
+			if c.Missing == joinMissingError {
+				missing := []string{}
+				for _, name := range names {
+					if !contains(r.inputs, name) {
+						missing = append(missing, name)
+					}
+				}
+				msg := fmt.Sprintf("no records for inputs %v with join key '%v'", missing, key)
+				ctx.fileError(r.file, c.sourceError(errors.New(msg)))
+			}
+			continue
+		}
+		data = c.appendRecord(ctx, data, r.file, r.mtime, r.vars, r.vars, &stats)
+	}
+	if skipped > 0 {
+		ctx.logger.Debugf("skipped %d of %d join keys for %s that are missing inputs", skipped, len(keys), c.source)
+	}
+	stats.log(ctx, len(keys)-skipped, c.source)
+	return data, nil
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJoin(t *testing.T) {
+
+	Convey("join inputs on a shared variable", t, func() {
+		fs, err := loadSnapshot("testdata/host.tar.gz")
+		So(err, ShouldBeNil)
+		configs, err := loadSetfile("testdata/docker-join.json")
+		So(err, ShouldBeNil)
+		So(validateSetfile("testdata/docker-join.json", nil), ShouldBeEmpty)
+
+		c := (*configs)[0]
+		mts, err := c.getMetricTypes()
+		So(err, ShouldBeNil)
+		So(len(mts), ShouldEqual, 2)
+
+		ctx := newCollectContext(log.New(), fs)
+		ms, err := c.collectMetrics(ctx, nil)
+		So(err, ShouldBeNil)
+		So(ctx.errors, ShouldBeEmpty)
+
+		values := map[string]interface{}{}
+		for _, m := range ms {
+			values[m.Namespace().String()] = m.Data()
+		}
+		So(len(values), ShouldEqual, 4)
+		prefix := "/netflix/linux/docker/9e8d7c6b5a4f3e2d1c0b9a8f7e6d5c4b3a2f1e0d9c8b7a6f5e4d3c2b1a0f9e8d"
+		So(values[prefix+"/memory/utilization"], ShouldEqual, 671744.0/2147483648.0)
+	})
+
+	Convey("missing inputs", t, func() {
+		fs := newMemFileSystem()
+		fs.add("/cgroup/a/usage", []byte("10\n"), time.Now())
+		fs.add("/cgroup/a/limit", []byte("100\n"), time.Now())
+		fs.add("/cgroup/b/usage", []byte("20\n"), time.Now())
+
+		c := fileConfig{
+			Inputs: map[string]inputConfig{
+				"usage": {"/cgroup/{id}/usage", newTableConfig([]string{"value"}, 0)},
+				"limit": {"/cgroup/{id}/limit", newTableConfig([]string{"value"}, 0)},
+			},
+			Join: []string{"id"},
+			Metrics: map[string]metricConfig{
+				"/test/{id}/utilization": newMetricConfig("{usage.value},{limit.value},:div"),
+			},
+			source: "a.json entry 0",
+		}
+
+		ctx := newCollectContext(log.New(), fs)
+		ms, err := c.collectMetrics(ctx, nil)
+		So(err, ShouldBeNil)
+		So(len(ms), ShouldEqual, 1)
+		So(ms[0].Data(), ShouldEqual, 0.1)
+		So(ctx.errors, ShouldBeEmpty)
+
+		c.Missing = joinMissingError
+		ctx = newCollectContext(log.New(), fs)
+		ms, err = c.collectMetrics(ctx, nil)
+		So(err, ShouldBeNil)
+		So(len(ms), ShouldEqual, 1)
+		So(ctx.errors[0].Error(), ShouldEqual, "/cgroup/b/usage: a.json entry 0: no records for inputs [limit] with join key 'b'")
+	})
+
+	Convey("validateJoin", t, func() {
+		c := fileConfig{
+			Inputs: map[string]inputConfig{
+				"usage": {"/cgroup/{id}/usage", newTableConfig([]string{"value"}, 0)},
+			},
+		}
+		So(c.validateJoin().Error(), ShouldEqual, "join must specify at least one variable when using inputs")
+
+		c.Join = []string{"id"}
+		c.Missing = "zero"
+		So(c.validateJoin().Error(), ShouldEqual, "unknown missing mode: 'zero', expected one of [skip error]")
+
+		c.Missing = ""
+		c.File = "/proc/loadavg"
+		So(c.validateJoin().Error(), ShouldEqual, "file cannot be used with inputs, specify the file for each input")
+
+		c.File = ""
+		c.Metrics = map[string]metricConfig{"/test/{id}/util": newMetricConfig("{usage.value},{limit.value},:div")}
+		errs := validateFileConfig(setfileEntry{"a.json", 0, 2, c}, nil)
+		So(errorStrings(errs), ShouldResemble, []string{
+			"a.json:2: entry 0: metrics[/test/{id}/util]: variable 'limit.value' is not produced by the parser",
+		})
+	})
+}
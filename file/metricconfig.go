@@ -0,0 +1,144 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Config for a single metric of a file config. In the setfile it can either
+// be a string with the expression for the value or an object, for example:
+//
+//	"metrics": {
+//	  "/linux/memory/rss": "{total_rss}",
+//	  "/linux/memory/pgfault": {
+//	    "expr": "{total_pgfault}",
+//	    "tags": {"id": "minor"},
+//	    "type": "counter"
+//	  }
+//	}
+type metricConfig struct {
+	Expr string `json:"expr"`
+
+	// Tags for this metric, these are merged with the tags of the file
+	// config and will override tags with the same key.
+	Tags map[string]string `json:"tags"`
+
+	Unit        string `json:"unit"`
+	Description string `json:"description"`
+
+	// Expression to filter the records for this metric, see
+	// fileConfig.Filter.
+	Filter string `json:"filter"`
+
+	// Data source type, one of metricTypes. If set, it will be added to the
+	// tags. The key can be set with the type_tag option in the plugin config
+	// to match the backend, e.g. atlas.dstype, and defaults to defaultTypeTag.
+	Type string `json:"type"`
+
+	// Settings for wildcard metrics, see isWildcard. The include and
+	// exclude patterns filter the keys of the record. The keys list is
+	// used to advertise the metrics that are expected to be available.
+	Include string   `json:"include"`
+	Exclude string   `json:"exclude"`
+	Keys    []string `json:"keys"`
+}
+
+var metricTypes = []string{"gauge", "counter"}
+
+const defaultTypeTag = "type"
+
+func newMetricConfig(expr string) metricConfig {
+	return metricConfig{Expr: expr}
+}
+
+func (m *metricConfig) UnmarshalJSON(data []byte) error {
+	expr := ""
+	if json.Unmarshal(data, &expr) == nil {
+		*m = newMetricConfig(expr)
+		return nil
+	}
+	type plain metricConfig
+	return json.Unmarshal(data, (*plain)(m))
+}
+
+// Expression for the value of the metric with the given namespace pattern.
+func (m metricConfig) expr(pattern string) string {
+	if m.Expr == "" && isWildcard(pattern) {
+		return "{" + valueVar + "}"
+	}
+	return m.Expr
+}
+
+// Check the fields that are not otherwise checked when the metric is
+// created.
+func (m metricConfig) validate(pattern string) error {
+	if m.expr(pattern) == "" {
+		return errors.New("expr must be specified")
+	}
+	if !isWildcard(pattern) && (m.Include != "" || m.Exclude != "" || len(m.Keys) > 0) {
+		return errors.New("include, exclude and keys can only be used with {" + keyVar + "}")
+	}
+	if strings.HasSuffix(pattern, "/{"+keyVar+"}") && len(m.Keys) == 0 {
+		return errors.New("keys must be specified if the namespace ends with {" + keyVar + "}")
+	}
+	if _, err := newKeyFilter(m.Include, m.Exclude); err != nil {
+		return err
+	}
+	if m.Type != "" && !contains(metricTypes, m.Type) {
+		return errors.New(fmt.Sprintf("unknown type: '%v', expected one of %v", m.Type, metricTypes))
+	}
+	if err := validateUnit(m.Unit); err != nil {
+		return err
+	}
+	if m.Filter != "" {
+		if _, err := checkExpr(m.Filter); err != nil {
+			return errors.New(fmt.Sprintf("filter: %v", err))
+		}
+	}
+	return nil
+}
+
+// Use the unit and description of the file config if they are not set for
+// the metric.
+func (m metricConfig) inherit(c fileConfig) metricConfig {
+	if m.Unit == "" {
+		m.Unit = c.Unit
+	}
+	if m.Description == "" {
+		m.Description = c.Description
+	}
+	return m
+}
+
+// Tags for the metric before substitution. The common tags are the tags from
+// the file config.
+func (m metricConfig) tags(common map[string]string, typeTag string) map[string]string {
+	tags := map[string]string{}
+	for k, v := range common {
+		tags[k] = v
+	}
+	for k, v := range m.Tags {
+		tags[k] = v
+	}
+	if m.Type != "" {
+		tags[typeTag] = m.Type
+	}
+	return tags
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Cases that can be used for normalizing keys.
+const (
+	caseLower = "lower"
+
+	// Lower case with words separated by '_', e.g. 'cpu MHz' will be mapped
+	// to 'cpu_mhz' and 'HugePages_Total' to 'huge_pages_total'.
+	caseSnake = "snake"
+)
+
+var keyCases = []string{caseLower, caseSnake}
+
+// Options for normalizing the names of the fields parsed from a file. The
+// normalized names are used for the record variables, so they apply to the
+// transforms, expressions, wildcard expansion and namespaces. For example,
+// for /proc/cpuinfo:
+//
+//	"normalize": {"case": "snake", "aliases": {"cpu_mhz": "frequency"}}
+//
+// The steps are applied in order: case, replace, strip_prefixes, and then
+// the aliases are looked up using the normalized name. An alias for the
+// original name will be used as is. If more than one field has the same
+// normalized name, then the last value will be used.
+type normalizeConfig struct {
+	Case string `json:"case"`
+
+	// If set, runs of characters other than letters, digits and '_' are
+	// replaced with this string.
+	Replace string `json:"replace"`
+
+	// Prefixes that will be removed, e.g. 'total_'. Only the first matching
+	// prefix is removed and the name is not changed if nothing would be left.
+	StripPrefixes []string `json:"strip_prefixes"`
+
+	// Map of name to the name that should be used instead.
+	Aliases map[string]string `json:"aliases"`
+}
+
+func (c normalizeConfig) validate() error {
+	if c.Case != "" && !contains(keyCases, c.Case) {
+		return errors.New(fmt.Sprintf("unknown case: '%v', expected one of %v", c.Case, keyCases))
+	}
+	for k, v := range c.Aliases {
+		if v == "" {
+			return errors.New(fmt.Sprintf("alias for '%v' cannot be empty", k))
+		}
+	}
+	return nil
+}
+
+func (c normalizeConfig) normalize(key string) string {
+	if alias, ok := c.Aliases[key]; ok {
+		return alias
+	}
+
+	name := key
+	switch c.Case {
+	case caseLower:
+		name = strings.ToLower(name)
+	case caseSnake:
+		name = snakeCase(name)
+	}
+	if c.Replace != "" {
+		name = invalidKeyChars.ReplaceAllString(name, c.Replace)
+	}
+	for _, prefix := range c.StripPrefixes {
+		if strings.HasPrefix(name, prefix) && len(name) > len(prefix) {
+			name = name[len(prefix):]
+			break
+		}
+	}
+
+	if alias, ok := c.Aliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+// Convert a name to lower case with '_' between the words. A new word starts
+// after characters other than letters and digits, or when an upper case
+// letter follows a lower case letter or digit.
+func snakeCase(name string) string {
+	words := []string{}
+	word := []rune{}
+	prev := rune(0)
+	for _, r := range name {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			if len(word) > 0 {
+				words = append(words, string(word))
+				word = []rune{}
+			}
+		case unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)) && len(word) > 0:
+			words = append(words, string(word))
+			word = []rune{unicode.ToLower(r)}
+		default:
+			word = append(word, unicode.ToLower(r))
+		}
+		prev = r
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+	return strings.Join(words, "_")
+}
+
+// Wrap the emit function for a parser so that the records have the
+// normalized names. The names are cached since most files repeat the same
+// keys for each record.
+func (c normalizeConfig) emitter(emit func(map[string]interface{})) func(map[string]interface{}) {
+	names := map[string]string{}
+	return func(record map[string]interface{}) {
+		result := make(map[string]interface{}, len(record))
+		for k, v := range record {
+			name, ok := names[k]
+			if !ok {
+				name = c.normalize(k)
+				names[k] = name
+			}
+			result[name] = v
+		}
+		emit(result)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNormalize(t *testing.T) {
+
+	Convey("snakeCase", t, func() {
+		So(snakeCase("cpu MHz"), ShouldEqual, "cpu_mhz")
+		So(snakeCase("VmRSS"), ShouldEqual, "vm_rss")
+		So(snakeCase("HugePages_Total"), ShouldEqual, "huge_pages_total")
+		So(snakeCase("Hugepagesize (kB)"), ShouldEqual, "hugepagesize_k_b")
+		So(snakeCase("rx2Bytes"), ShouldEqual, "rx2_bytes")
+		So(snakeCase("__a--b__"), ShouldEqual, "a_b")
+	})
+
+	Convey("normalize", t, func() {
+		c := normalizeConfig{Case: "lower", Replace: "_", StripPrefixes: []string{"total_"}}
+		So(c.normalize("Total_RSS"), ShouldEqual, "rss")
+		So(c.normalize("model name"), ShouldEqual, "model_name")
+		So(c.normalize("total_"), ShouldEqual, "total_")
+
+		c = normalizeConfig{
+			Case:    "snake",
+			Aliases: map[string]string{"cpu_mhz": "frequency", "model name": "model"},
+		}
+		So(c.normalize("cpu MHz"), ShouldEqual, "frequency")
+		So(c.normalize("model name"), ShouldEqual, "model")
+		So(c.normalize("cache size"), ShouldEqual, "cache_size")
+	})
+
+	Convey("validate", t, func() {
+		cfg := newTableConfig([]string{"Total RSS", "cpu MHz"}, 0)
+		cfg.Normalize = &normalizeConfig{Case: "camel"}
+		So(cfg.validate().Error(), ShouldEqual, "normalize: unknown case: 'camel', expected one of [lower snake]")
+
+		cfg.Normalize = &normalizeConfig{Aliases: map[string]string{"a": ""}}
+		So(cfg.validate().Error(), ShouldEqual, "normalize: alias for 'a' cannot be empty")
+
+		cfg.Normalize = &normalizeConfig{Case: "snake", StripPrefixes: []string{"total_"}}
+		So(cfg.validate(), ShouldBeNil)
+		columns, ok := cfg.variables()
+		So(ok, ShouldBeTrue)
+		So(columns, ShouldResemble, []string{"rss", "cpu_mhz"})
+	})
+
+	Convey("parse /proc/cpuinfo", t, func() {
+		cfg := newKeyValueConfig("\n\n", ":")
+		cfg.Normalize = &normalizeConfig{Case: "snake"}
+		cfg.Transforms = map[string]transformPipeline{"fpu": newTransformPipeline("bool")}
+		rows, err := newParser(cfg).parseFile(osFileSystem{}, "testdata/cpuinfo")
+		So(err, ShouldBeNil)
+		So(len(rows), ShouldEqual, 2)
+		So(rows[0]["cpu_mhz"], ShouldEqual, 2494.068)
+		So(rows[0]["model_name"], ShouldEqual, "Intel(R) Xeon(R) CPU E5-2670 v2 @ 2.50GHz")
+		So(rows[0]["fpu"], ShouldEqual, 1.0)
+		So(rows[0], ShouldNotContainKey, "cpu MHz")
+	})
+
+	Convey("normalized keys are used for wildcards", t, func() {
+		cfg := newKeyValueConfig("\n\n", ":")
+		cfg.Normalize = &normalizeConfig{Case: "snake", Aliases: map[string]string{"cpu_mhz": "frequency"}}
+		c := fileConfig{
+			File: "testdata/cpuinfo",
+			Metrics: map[string]metricConfig{
+				"/test/cpu/{processor}/{__key__}": newMetricConfig("{__value__}"),
+				"/test/cpu/{processor}/mhz":       newMetricConfig("{frequency}"),
+			},
+			Parser: cfg,
+		}
+
+		ms, err := c.collectMetrics(newCollectContext(log.New(), osFileSystem{}), nil)
+		So(err, ShouldBeNil)
+
+		values := map[string]interface{}{}
+		for _, m := range ms {
+			values[m.Namespace().String()] = m.Data()
+		}
+		So(values["/test/cpu/0/frequency"], ShouldEqual, 2494.068)
+		So(values["/test/cpu/0/mhz"], ShouldEqual, 2494.068)
+		So(values["/test/cpu/1/cache_size"], ShouldBeNil)
+		So(values, ShouldNotContainKey, "/test/cpu/0/cpu_MHz")
+	})
+
+	Convey("include matches the normalized keys", t, func() {
+		cfg := newKeyValueConfig("\n\n", " ")
+		cfg.Normalize = &normalizeConfig{Aliases: map[string]string{"total_rss": "resident", "total_cache": "page_cache"}}
+		c := fileConfig{
+			File: "testdata/memory.stat",
+			Metrics: map[string]metricConfig{
+				"/test/memory/{__key__}/value": {Include: "^(resident|page_cache|total_rss)$"},
+			},
+			Parser: cfg,
+		}
+
+		ms, err := c.collectMetrics(newCollectContext(log.New(), osFileSystem{}), nil)
+		So(err, ShouldBeNil)
+
+		values := map[string]interface{}{}
+		for _, m := range ms {
+			values[m.Namespace().String()] = m.Data()
+		}
+		So(values, ShouldResemble, map[string]interface{}{
+			"/test/memory/resident/value":   327680.0,
+			"/test/memory/page_cache/value": 4096.0,
+		})
+	})
+}
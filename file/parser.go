@@ -4,7 +4,6 @@ import (
 	"strconv"
 	"strings"
 	"regexp"
-	"io/ioutil"
 	"fmt"
 	"errors"
 )
@@ -19,8 +18,8 @@ func newParser(config parserConfig) parser {
 	}
 }
 
-func (p parser) parseFile(file string) ([]map[string]interface{}, error) {
-	data, err := ioutil.ReadFile(file)
+func (p parser) parseFile(fs fileSystem, file string) ([]map[string]interface{}, error) {
+	data, err := fs.readFile(file)
 	if err != nil {
 		return nil, err
 	}
@@ -28,18 +27,73 @@ func (p parser) parseFile(file string) ([]map[string]interface{}, error) {
 }
 
 func (p parser) parseString(data string) ([]map[string]interface{}, error) {
+	rows := []map[string]interface{}{}
+	emit := collect(&rows)
+
+	// If aggregating, the records are folded into the groups as they are
+	// parsed rather than keeping a record for each line
+	var agg *aggregator
+	if p.config.Aggregate != nil {
+		agg = newAggregator(*p.config.Aggregate)
+		emit = agg.add
+	}
+	if p.config.Normalize != nil {
+		emit = p.config.Normalize.emitter(emit)
+	}
+
+	var err error
 	switch p.config.Format {
 	case "table":
-		return parseTable(data, p.config.Columns, p.config.Skip)
+		err = scanTable(data, p.config.Columns, p.config.Skip, p.value, emit)
 	case "key-value":
-		return parseKeyValueList(data, p.config.RecordSep, p.config.FieldSep), nil
+		scanKeyValueList(data, p.config.RecordSep, p.config.FieldSep, p.value, emit)
 	case "key-row":
-		return parseKeyRow(data)
+		err = scanKeyRow(data, p.value, emit)
+	case "procstat":
+		scanProcStat(data, p.config.Columns, p.value, emit)
 	case "regexp":
-		return parseRegexp(data, p.config.RecordSep, p.config.Columns, p.config.regexp())
+		re, rerr := p.config.regexp()
+		if rerr != nil {
+			return nil, rerr
+		}
+		err = scanRegexp(data, p.config.RecordSep, p.config.Columns, re, p.value, emit)
 	default:
 		return nil, errors.New(fmt.Sprintf("unknown file format: '%v'", p.config.Format))
 	}
+
+	if agg != nil {
+		if err == nil {
+			err = agg.err
+		}
+		return agg.records(), err
+	}
+	return rows, err
+}
+
+// Convert the raw string for a field to a value, applying the transform for
+// the field if there is one. Transforms use the normalized name of the field.
+func (p parser) value(key string, data string) interface{} {
+	if p.config.Normalize != nil && len(p.config.Transforms) > 0 {
+		key = p.config.Normalize.normalize(key)
+	}
+	if t, ok := p.config.Transforms[key]; ok {
+		return t.apply(data)
+	}
+	return parseValue(data)
+}
+
+// Function used to convert the raw string for a field to a value.
+type valueParser func(key string, data string) interface{}
+
+func defaultValue(key string, data string) interface{} {
+	return parseValue(data)
+}
+
+// Create a function for collecting the records emitted while scanning.
+func collect(rows *[]map[string]interface{}) func(map[string]interface{}) {
+	return func(row map[string]interface{}) {
+		*rows = append(*rows, row)
+	}
 }
 
 func parseValue(data string) interface{} {
@@ -53,6 +107,10 @@ func parseValue(data string) interface{} {
 }
 
 func parseKeyValue(data string, fieldSep string) map[string]interface{} {
+	return keyValueRecord(data, fieldSep, defaultValue)
+}
+
+func keyValueRecord(data string, fieldSep string, value valueParser) map[string]interface{} {
 	lines := strings.Split(data, "\n")
 	values := map[string]interface{}{}
 	for _, line := range lines {
@@ -67,7 +125,7 @@ func parseKeyValue(data string, fieldSep string) map[string]interface{} {
 		if len(fields) >= 2 {
 			// If the field name ends with a ':' strip it out
 			k := strings.Trim(fields[0], " :")
-			values[k] = parseValue(fields[1])
+			values[k] = value(k, fields[1])
 		}
 	}
   return values
@@ -75,21 +133,30 @@ func parseKeyValue(data string, fieldSep string) map[string]interface{} {
 
 func parseKeyValueList(data string, recordSep string, fieldSep string) []map[string]interface{} {
 	items := []map[string]interface{}{}
+	scanKeyValueList(data, recordSep, fieldSep, defaultValue, collect(&items))
+	return items
+}
+
+func scanKeyValueList(data string, recordSep string, fieldSep string, value valueParser, emit func(map[string]interface{})) {
 	records := strings.Split(data, recordSep)
 	for _, record := range records {
-		item := parseKeyValue(record, fieldSep)
+		item := keyValueRecord(record, fieldSep, value)
 		if len(item) > 0 {
-			items = append(items, item)
+			emit(item)
 		}
 	}
-	return items
 }
 
 func parseKeyRow(data string) ([]map[string]interface{}, error) {
 	rows := []map[string]interface{}{}
+	err := scanKeyRow(data, defaultValue, collect(&rows))
+	return rows, err
+}
+
+func scanKeyRow(data string, value valueParser, emit func(map[string]interface{})) error {
 	lines := strings.Split(strings.Trim(data, "\n"), "\n")
 	if len(lines) % 2 != 0 {
-		return rows, errors.New("key-row format requires even number of lines")
+		return errors.New("key-row format requires even number of lines")
 	}
 
 	for i := 0; i < len(lines); i += 2 {
@@ -101,13 +168,13 @@ func parseKeyRow(data string) ([]map[string]interface{}, error) {
 
 		// Lines cannot be empty. Maybe these should be ignored instead?
 		if hlen == 0 {
-			return rows, errors.New(fmt.Sprintf("line %v, empty lines are not allowed", i))
+			return errors.New(fmt.Sprintf("line %v, empty lines are not allowed", i))
 		}
 
 		// Number of headers must match number of values
 		if hlen != vlen {
 			msg := fmt.Sprintf("line %v, different number of columns: '%v' != '%v'", i, hlen, vlen)
-			return rows, errors.New(msg)
+			return errors.New(msg)
 		}
 
 		// Check that the ids are the same
@@ -115,7 +182,7 @@ func parseKeyRow(data string) ([]map[string]interface{}, error) {
 		vid := strings.Trim(values[0], ":")
 		if hid != vid {
 			msg := fmt.Sprintf("line %v, rows ids do not match: '%v' != '%v'", i, hid, vid)
-			return rows, errors.New(msg)
+			return errors.New(msg)
 		}
 
 		row := map[string]interface{}{
@@ -123,21 +190,26 @@ func parseKeyRow(data string) ([]map[string]interface{}, error) {
 		}
 
 		for j := 1; j < hlen; j++ {
-			row[headers[j]] = parseValue(values[j])
+			row[headers[j]] = value(headers[j], values[j])
 		}
 
-		rows = append(rows, row)
+		emit(row)
 	}
-	return rows, nil
+	return nil
 }
 
 func parseTable(data string, columns []string, skip uint32) ([]map[string]interface{}, error) {
 	rows := []map[string]interface{}{}
+	err := scanTable(data, columns, skip, defaultValue, collect(&rows))
+	return rows, err
+}
+
+func scanTable(data string, columns []string, skip uint32, value valueParser, emit func(map[string]interface{})) error {
 	lines := strings.Split(strings.Trim(data, "\n"), "\n")
 	if int(skip) <= len(lines) {
 		lines = lines[skip:]
 	} else {
-		return rows, nil
+		return nil
 	}
 
 	headers := columns
@@ -151,17 +223,70 @@ func parseTable(data string, columns []string, skip uint32) ([]map[string]interf
 		if len(values) == len(headers) {
 			row := map[string]interface{}{}
 			for i, v := range values {
-				row[headers[i]] = parseValue(v)
+				row[headers[i]] = value(headers[i], v)
 			}
-			rows = append(rows, row)
+			emit(row)
 		}
 	}
 
-	return rows, nil
+	return nil
+}
+
+// Names of the fields in /proc/[pid]/stat, see proc(5).
+var procStatColumns = []string{
+	"pid", "comm", "state", "ppid", "pgrp", "session", "tty_nr", "tpgid",
+	"flags", "minflt", "cminflt", "majflt", "cmajflt", "utime", "stime",
+	"cutime", "cstime", "priority", "nice", "num_threads", "itrealvalue",
+	"starttime", "vsize", "rss", "rsslim", "startcode", "endcode",
+	"startstack", "kstkesp", "kstkeip", "signal", "blocked", "sigignore",
+	"sigcatch", "wchan", "nswap", "cnswap", "exit_signal", "processor",
+	"rt_priority", "policy", "delayacct_blkio_ticks", "guest_time",
+	"cguest_time", "start_data", "end_data", "start_brk", "arg_start",
+	"arg_end", "env_start", "env_end", "exit_code",
+}
+
+// Parse lines in the format of /proc/[pid]/stat. The second field is the
+// command name in parentheses, which can contain spaces and ')', so it ends
+// at the last ')' on the line. The comm and state are kept as strings. If no
+// columns are specified, then the names from proc(5) are used. Values beyond
+// the last column are ignored.
+func scanProcStat(data string, columns []string, value valueParser, emit func(map[string]interface{})) {
+	if len(columns) == 0 {
+		columns = procStatColumns
+	}
+
+	for _, line := range strings.Split(data, "\n") {
+		start := strings.Index(line, "(")
+		end := strings.LastIndex(line, ")")
+		if start < 0 || end < start {
+			continue
+		}
+
+		values := []string{strings.TrimSpace(line[:start]), line[start+1 : end]}
+		values = append(values, strings.Fields(line[end+1:])...)
+
+		row := map[string]interface{}{}
+		for i, v := range values {
+			if i >= len(columns) {
+				break
+			}
+			if i == 1 || i == 2 {
+				row[columns[i]] = v
+			} else {
+				row[columns[i]] = value(columns[i], v)
+			}
+		}
+		emit(row)
+	}
 }
 
 func parseRegexp(data string, recordSep string, columns []string, pattern *regexp.Regexp) ([]map[string]interface{}, error) {
 	items := []map[string]interface{}{}
+	err := scanRegexp(data, recordSep, columns, pattern, defaultValue, collect(&items))
+	return items, err
+}
+
+func scanRegexp(data string, recordSep string, columns []string, pattern *regexp.Regexp, value valueParser, emit func(map[string]interface{})) error {
 	records := strings.Split(data, recordSep)
 
 	clen := len(columns)
@@ -175,15 +300,15 @@ func parseRegexp(data string, recordSep string, columns []string, pattern *regex
 		vlen := len(values)
 		if clen != vlen {
 			msg := fmt.Sprintf("record %v, different number of columns: '%v' != '%v'", i, clen, vlen)
-			return items, errors.New(msg)
+			return errors.New(msg)
 		}
 
 		item := map[string]interface{}{}
 		for j, v := range values {
-			item[columns[j]] = parseValue(v)
+			item[columns[j]] = value(columns[j], v)
 		}
-		items = append(items, item)
+		emit(item)
 	}
 
-	return items, nil
+	return nil
 }
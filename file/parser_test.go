@@ -245,7 +245,7 @@ func TestParser(t *testing.T) {
 
 	Convey("parse /proc/cpuinfo", t, func() {
 		p := newParser(newKeyValueConfig("\n\n", ":"))
-		rows, err := p.parseFile("testdata/cpuinfo")
+		rows, err := p.parseFile(osFileSystem{}, "testdata/cpuinfo")
 		So(err, ShouldBeNil)
 		So(len(rows), ShouldEqual, 2)
 		for i, row := range rows {
@@ -255,7 +255,7 @@ func TestParser(t *testing.T) {
 
 	Convey("parse /proc/loadavg", t, func() {
 		p := newParser(newTableConfig([]string{"1m", "5m", "15m", "running/total", "last_pid"}, 0))
-		rows, err := p.parseFile("testdata/loadavg")
+		rows, err := p.parseFile(osFileSystem{}, "testdata/loadavg")
 		So(err, ShouldBeNil)
 		So(len(rows), ShouldEqual, 1)
 		So(rows[0]["1m"], ShouldResemble, 0.01)
@@ -267,7 +267,7 @@ func TestParser(t *testing.T) {
 
 	Convey("parse /proc/net/netstat", t, func() {
 		p := newParser(newKeyRowConfig())
-		rows, err := p.parseFile("testdata/netstat")
+		rows, err := p.parseFile(osFileSystem{}, "testdata/netstat")
 		So(err, ShouldBeNil)
 		So(len(rows), ShouldEqual, 2)
 
@@ -299,7 +299,7 @@ func TestParser(t *testing.T) {
 			"send_compressed",
 		}
 		p := newParser(newTableConfig(columns, 2))
-		rows, err := p.parseFile("testdata/net_dev")
+		rows, err := p.parseFile(osFileSystem{}, "testdata/net_dev")
 		So(err, ShouldBeNil)
 		So(len(rows), ShouldEqual, 11)
 
@@ -325,11 +325,33 @@ func TestParser(t *testing.T) {
 		}
 		pattern := "(cpu\\d*)\\s+(\\d+)\\s+(\\d+)\\s+(\\d+)\\s+(\\d+)\\s+(\\d+)\\s+(\\d+)\\s+(\\d+)\\s+(\\d+)\\s+(\\d+)\\s+(\\d+)"
 		p := newParser(newRegexpConfig(columns, pattern))
-		rows, err := p.parseFile("testdata/stat")
+		rows, err := p.parseFile(osFileSystem{}, "testdata/stat")
 		So(err, ShouldBeNil)
 		So(len(rows), ShouldEqual, 3)
 
 		So(rows[1]["label"], ShouldResemble, "cpu0")
 		So(rows[2]["irq"], ShouldResemble, 122.0)
 	})
+
+	Convey("parse /proc/[pid]/stat", t, func() {
+		p := newParser(newProcStatConfig())
+		rows, err := p.parseFile(osFileSystem{}, "testdata/pid_stat")
+		So(err, ShouldBeNil)
+		So(len(rows), ShouldEqual, 1)
+
+		So(rows[0]["pid"], ShouldResemble, 4242.0)
+		So(rows[0]["comm"], ShouldResemble, "my (odd) proc")
+		So(rows[0]["state"], ShouldResemble, "S")
+		So(rows[0]["utime"], ShouldResemble, 1532.0)
+		So(rows[0]["stime"], ShouldResemble, 418.0)
+		So(rows[0]["num_threads"], ShouldResemble, 17.0)
+		So(rows[0]["rss"], ShouldResemble, 45678.0)
+		So(rows[0]["exit_code"], ShouldResemble, 0.0)
+
+		rows, err = newParser(parserConfig{Format: "procstat", Columns: []string{"id", "name", "state", "parent"}}).parseString("7 (a b) R 1 7 7\nbad line\n")
+		So(err, ShouldBeNil)
+		So(rows, ShouldResemble, []map[string]interface{}{
+			{"id": 7.0, "name": "a b", "state": "R", "parent": 1.0},
+		})
+	})
 }
@@ -1,9 +1,20 @@
 package file
 
 import (
+	"errors"
+	"fmt"
 	"regexp"
 )
 
+// Names of the supported file formats.
+var formats = []string{
+	"table",
+	"key-value",
+	"key-row",
+	"regexp",
+	"procstat",
+}
+
 type parserConfig struct {
 	Format    string     `json:"format"`
 
@@ -17,6 +28,17 @@ type parserConfig struct {
 	Pattern   string     `json:"pattern"`
 
 	Skip      uint32     `json:"skip"`
+
+	// Map of field name to the transforms used to convert the raw value,
+	// see transformStep.
+	Transforms map[string]transformPipeline `json:"transforms"`
+
+	// If set, the records of a file are combined into one per group, see
+	// aggregateConfig.
+	Aggregate *aggregateConfig   `json:"aggregate"`
+
+	// Options for normalizing the field names, see normalizeConfig.
+	Normalize *normalizeConfig   `json:"normalize"`
 }
 
 func defaultKeyValueConfig() parserConfig {
@@ -31,6 +53,9 @@ func newKeyValueConfig(recordSep string, fieldSep string) parserConfig {
 		fieldSep,
 		"",
 		0,
+		nil,
+		nil,
+		nil,
 	}
 }
 
@@ -42,6 +67,9 @@ func newKeyRowConfig() parserConfig {
 		"",
 		"",
 		0,
+		nil,
+		nil,
+		nil,
 	}
 }
 
@@ -53,6 +81,23 @@ func newTableConfig(columns []string, skip uint32) parserConfig {
 		"",
 		"",
 		skip,
+		nil,
+		nil,
+		nil,
+	}
+}
+
+func newProcStatConfig() parserConfig {
+	return parserConfig{
+		"procstat",
+		[]string{},
+		"",
+		"",
+		"",
+		0,
+		nil,
+		nil,
+		nil,
 	}
 }
 
@@ -64,9 +109,90 @@ func newRegexpConfig(columns []string, pattern string) parserConfig {
 		"",
 		pattern,
 		0,
+		nil,
+		nil,
+		nil,
+	}
+}
+
+func (c parserConfig) regexp() (*regexp.Regexp, error) {
+	return regexp.Compile(c.Pattern)
+}
+
+// Check that the config can be used to create a working parser.
+func (c parserConfig) validate() error {
+	known := false
+	for _, f := range formats {
+		known = known || f == c.Format
+	}
+	if !known {
+		return errors.New(fmt.Sprintf("unknown file format: '%v', expected one of %v", c.Format, formats))
+	}
+
+	if c.Format == "regexp" {
+		re, err := c.regexp()
+		if err != nil {
+			return err
+		}
+		if re.NumSubexp() != len(c.Columns) {
+			msg := fmt.Sprintf("pattern has %d groups, but there are %d columns", re.NumSubexp(), len(c.Columns))
+			return errors.New(msg)
+		}
+	}
+
+	if c.Normalize != nil {
+		if err := c.Normalize.validate(); err != nil {
+			return errors.New(fmt.Sprintf("normalize: %v", err))
+		}
+	}
+	if err := validateTransforms(c.Transforms); err != nil {
+		return err
+	}
+	if c.Aggregate != nil {
+		columns, ok := c.columns()
+		if err := c.Aggregate.validate(columns, ok); err != nil {
+			return errors.New(fmt.Sprintf("aggregate: %v", err))
+		}
 	}
+	return nil
 }
 
-func (c parserConfig) regexp() *regexp.Regexp {
-	return regexp.MustCompile(c.Pattern)
+// Names of the variables that will be present in the records produced by the
+// parser. The second return value is false if the names depend on the
+// content of the file.
+func (c parserConfig) variables() ([]string, bool) {
+	if c.Aggregate != nil {
+		return c.Aggregate.variables(), true
+	}
+	return c.columns()
+}
+
+// Names of the fields parsed from the file before aggregation, after the
+// names have been normalized.
+func (c parserConfig) columns() ([]string, bool) {
+	columns, ok := c.rawColumns()
+	if c.Normalize == nil || columns == nil {
+		return columns, ok
+	}
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = c.Normalize.normalize(column)
+	}
+	return names, ok
+}
+
+func (c parserConfig) rawColumns() ([]string, bool) {
+	switch c.Format {
+	case "table":
+		return c.Columns, len(c.Columns) > 0
+	case "regexp":
+		return c.Columns, true
+	case "procstat":
+		if len(c.Columns) > 0 {
+			return c.Columns, true
+		}
+		return procStatColumns, true
+	default:
+		return nil, false
+	}
 }
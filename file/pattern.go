@@ -0,0 +1,154 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// File pattern with optional named captures. A capture is written as
+// '{name}' to match any single path segment, or '{name:regex}' to constrain
+// the match, for example:
+//
+//	/sys/fs/cgroup/cpu/docker/{container:[0-9a-f]{64}}/cpu.shares
+//
+// Captures are replaced with '*' to get the glob used for finding files and
+// the values for the matched file are made available as record variables.
+type filePattern struct {
+	pattern string
+	glob    string
+	names   []string
+	regexp  *regexp.Regexp
+}
+
+var captureName = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_.-]*$")
+
+func newFilePattern(pattern string) (*filePattern, error) {
+	glob := bytes.Buffer{}
+	re := bytes.Buffer{}
+	names := []string{}
+
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '{':
+			end, err := findCaptureEnd(pattern, i)
+			if err != nil {
+				return nil, err
+			}
+			capture := pattern[i+1 : end]
+			name, expr := capture, "[^/]+"
+			if pos := strings.Index(capture, ":"); pos >= 0 {
+				name, expr = capture[:pos], capture[pos+1:]
+			}
+			if !captureName.MatchString(name) {
+				msg := fmt.Sprintf("invalid capture name '%v' in file pattern: '%v'", name, pattern)
+				return nil, errors.New(msg)
+			}
+			if _, err := regexp.Compile(expr); err != nil {
+				msg := fmt.Sprintf("invalid expression for capture '%v' in file pattern '%v': %v", name, pattern, err)
+				return nil, errors.New(msg)
+			}
+			names = append(names, name)
+			glob.WriteString("*")
+			re.WriteString(fmt.Sprintf("(?P<c%d>%s)", len(names)-1, expr))
+			i = end
+		case '*':
+			glob.WriteByte(c)
+			re.WriteString("[^/]*")
+		case '?':
+			glob.WriteByte(c)
+			re.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				msg := fmt.Sprintf("unterminated character class in file pattern: '%v'", pattern)
+				return nil, errors.New(msg)
+			}
+			class := pattern[i : i+end+1]
+			glob.WriteString(class)
+			re.WriteString(class)
+			i += end
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				glob.WriteByte(c)
+				glob.WriteByte(pattern[i])
+				re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		default:
+			glob.WriteByte(c)
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	re.WriteString("$")
+
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return &filePattern{
+		pattern: pattern,
+		glob:    glob.String(),
+		names:   names,
+		regexp:  compiled,
+	}, nil
+}
+
+// Find the index of the '}' that closes the capture starting at the given
+// position. Braces inside the capture, e.g. for a repetition like '{64}' in
+// the regex, are balanced.
+func findCaptureEnd(pattern string, start int) (int, error) {
+	depth := 0
+	for i := start; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	msg := fmt.Sprintf("unterminated capture in file pattern: '%v'", pattern)
+	return -1, errors.New(msg)
+}
+
+// Check if a file found with the glob matches the pattern and return the
+// values of the named captures.
+func (p *filePattern) match(file string) (map[string]interface{}, bool) {
+	values := p.regexp.FindStringSubmatch(file)
+	if values == nil {
+		return nil, false
+	}
+
+	// Group names are generated from the position of the capture so that
+	// groups used within a capture expression do not shift the values.
+	captures := map[string]interface{}{}
+	for i, name := range p.names {
+		captures[name] = values[p.regexp.SubexpIndex(fmt.Sprintf("c%d", i))]
+	}
+	return captures, true
+}
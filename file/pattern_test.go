@@ -0,0 +1,80 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFilePattern(t *testing.T) {
+
+	Convey("newFilePattern", t, func() {
+		p, err := newFilePattern("/proc/loadavg")
+		So(err, ShouldBeNil)
+		So(p.glob, ShouldEqual, "/proc/loadavg")
+		So(p.names, ShouldResemble, []string{})
+
+		p, err = newFilePattern("/sys/fs/cgroup/cpu/docker/{container}/cpu.shares")
+		So(err, ShouldBeNil)
+		So(p.glob, ShouldEqual, "/sys/fs/cgroup/cpu/docker/*/cpu.shares")
+		So(p.names, ShouldResemble, []string{"container"})
+
+		p, err = newFilePattern("/sys/fs/cgroup/cpu/docker/{container:[0-9a-f]{4}}/cpu.shares")
+		So(err, ShouldBeNil)
+		So(p.glob, ShouldEqual, "/sys/fs/cgroup/cpu/docker/*/cpu.shares")
+		So(p.names, ShouldResemble, []string{"container"})
+
+		p, err = newFilePattern("/sys/block/sd[a-z]/{dev}p{part:\\d+}/stat")
+		So(err, ShouldBeNil)
+		So(p.glob, ShouldEqual, "/sys/block/sd[a-z]/*p*/stat")
+		So(p.names, ShouldResemble, []string{"dev", "part"})
+
+		_, err = newFilePattern("/foo/{bar/baz")
+		So(err.Error(), ShouldEqual, "unterminated capture in file pattern: '/foo/{bar/baz'")
+
+		_, err = newFilePattern("/foo/{b r}/baz")
+		So(err.Error(), ShouldEqual, "invalid capture name 'b r' in file pattern: '/foo/{b r}/baz'")
+
+		_, err = newFilePattern("/foo/{bar:(}/baz")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("match", t, func() {
+		p, _ := newFilePattern("/docker/{container:[0-9a-f]{4}}/cpu.shares")
+
+		captures, ok := p.match("/docker/00ff/cpu.shares")
+		So(ok, ShouldBeTrue)
+		So(captures, ShouldResemble, map[string]interface{}{
+			"container": "00ff",
+		})
+
+		_, ok = p.match("/docker/00fg/cpu.shares")
+		So(ok, ShouldBeFalse)
+
+		_, ok = p.match("/docker/00ff0/cpu.shares")
+		So(ok, ShouldBeFalse)
+
+		p, _ = newFilePattern("/docker/{a:(x|y)}-{b}/*.stat")
+		captures, ok = p.match("/docker/y-foo/memory.stat")
+		So(ok, ShouldBeTrue)
+		So(captures, ShouldResemble, map[string]interface{}{
+			"a": "y",
+			"b": "foo",
+		})
+	})
+}
@@ -0,0 +1,388 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const pidVar = "pid"
+
+// Selects the processes to collect for a file pattern with a pid capture such
+// as '/proc/{pid}/status'. Only the processes that match one of the groups
+// will be used and the records will have the variables 'pid', 'comm' and
+// 'group'. For example:
+//
+//	"process": {
+//	  "groups": [
+//	    {"name": "nginx", "comm": "^nginx$"},
+//	    {"name": "java", "exe": "/java$", "user": "^www-data$"}
+//	  ],
+//	  "count_fds": true
+//	}
+//
+// If aggregate is true, then the values of the records will be summed for all
+// processes in a group. The records will have the variables 'group' and
+// 'processes', the number of processes in the group, instead of the pid and
+// comm. Only the fields listed in sum are kept, by default the counters and
+// sizes from /proc/[pid]/stat, status and io. Other fields such as the ppid
+// or starttime identify a single process and summing them is meaningless.
+type processConfig struct {
+	// Directory with the process directories, defaults to /proc.
+	Proc string `json:"proc"`
+
+	Groups []processGroup `json:"groups"`
+
+	// Add the variable 'fds' with the number of open file descriptors.
+	CountFds bool `json:"count_fds"`
+
+	Aggregate bool `json:"aggregate"`
+
+	// Fields to sum when aggregating, defaults to defaultSumFields.
+	Sum []string `json:"sum"`
+}
+
+// Fields of the files in /proc/[pid] that can be summed for a group of
+// processes, see proc(5).
+var defaultSumFields = []string{
+	// stat
+	"minflt", "cminflt", "majflt", "cmajflt", "utime", "stime", "cutime",
+	"cstime", "num_threads", "vsize", "rss", "nswap", "cnswap",
+	"delayacct_blkio_ticks", "guest_time", "cguest_time",
+
+	// status
+	"VmSize", "VmLck", "VmPin", "VmRSS", "RssAnon", "RssFile", "RssShmem",
+	"VmData", "VmStk", "VmExe", "VmLib", "VmPTE", "VmSwap", "Threads",
+	"voluntary_ctxt_switches", "nonvoluntary_ctxt_switches",
+
+	// io
+	"rchar", "wchar", "syscr", "syscw", "read_bytes", "write_bytes",
+	"cancelled_write_bytes",
+}
+
+// Rule for matching processes. All of the expressions that are set must match
+// for a process to be part of the group. If a process matches more than one
+// group, then the first will be used.
+type processGroup struct {
+	Name string `json:"name"`
+
+	// Name of the executable from /proc/[pid]/comm.
+	Comm string `json:"comm"`
+
+	// Command line with the arguments separated by spaces.
+	Cmdline string `json:"cmdline"`
+
+	// Path of the executable.
+	Exe string `json:"exe"`
+
+	// User name or uid of the real user for the process.
+	User string `json:"user"`
+}
+
+func (c processConfig) proc() string {
+	if c.Proc != "" {
+		return c.Proc
+	}
+	return "/proc"
+}
+
+func (c processConfig) sumFields() map[string]bool {
+	fields := c.Sum
+	if len(fields) == 0 {
+		fields = defaultSumFields
+	}
+	result := map[string]bool{}
+	for _, f := range fields {
+		result[f] = true
+	}
+	return result
+}
+
+// Remove the variables that are dropped when aggregating, the pid and the
+// fields of the records that are not summed.
+func (c processConfig) aggregateVars(vars map[string]bool, pattern *filePattern) {
+	sum := c.sumFields()
+	env := defaultVars()
+	for k := range vars {
+		_, isEnv := env[k]
+		if k == pidVar || (!sum[k] && !isEnv && !contains(pattern.names, k)) {
+			delete(vars, k)
+		}
+	}
+}
+
+func (c processConfig) validate() error {
+	if len(c.Groups) == 0 {
+		return errors.New("at least one group must be specified")
+	}
+	names := map[string]bool{}
+	for i, g := range c.Groups {
+		if err := g.validate(); err != nil {
+			return errors.New(fmt.Sprintf("groups[%d]: %v", i, err))
+		}
+		if names[g.Name] {
+			return errors.New(fmt.Sprintf("groups[%d]: duplicate group name '%v'", i, g.Name))
+		}
+		names[g.Name] = true
+	}
+	return nil
+}
+
+func (g processGroup) validate() error {
+	if g.Name == "" {
+		return errors.New("name must be specified")
+	}
+	if g.Comm == "" && g.Cmdline == "" && g.Exe == "" && g.User == "" {
+		return errors.New("at least one of comm, cmdline, exe or user must be specified")
+	}
+	for _, p := range []string{g.Comm, g.Cmdline, g.Exe, g.User} {
+		if p == "" {
+			continue
+		}
+		if _, err := compilePattern(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check that the file pattern captures the pid.
+func (c processConfig) validatePattern(file string) error {
+	pattern, err := newFilePattern(file)
+	if err != nil {
+		return err
+	}
+	if !contains(pattern.names, pidVar) {
+		msg := fmt.Sprintf("file pattern must capture the pid when using process, e.g. '/proc/{pid}/status': '%v'", file)
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// Names of the variables that will be added to the records.
+func (c processConfig) variables() []string {
+	vars := []string{"group"}
+	if c.Aggregate {
+		vars = append(vars, "processes")
+	} else {
+		vars = append(vars, "comm", pidVar)
+	}
+	if c.CountFds {
+		vars = append(vars, "fds")
+	}
+	sort.Strings(vars)
+	return vars
+}
+
+// Matches processes against the groups for a single collection. The user
+// names are loaded on first use.
+type processMatcher struct {
+	config processConfig
+	fs     fileSystem
+	users  map[string]string
+}
+
+func newProcessMatcher(c processConfig, fs fileSystem) *processMatcher {
+	return &processMatcher{config: c, fs: fs}
+}
+
+// Check if the process for the captures of a file matches one of the groups.
+// If so, then the captures with the process variables added are returned.
+func (m *processMatcher) match(captures map[string]interface{}) (map[string]interface{}, bool) {
+	pid := fmt.Sprintf("%v", captures[pidVar])
+	if _, err := strconv.Atoi(pid); err != nil {
+		return nil, false
+	}
+	dir := path.Join(m.config.proc(), pid)
+
+	// The process may have exited, in which case the files will be missing
+	// and it will not match
+	comm, err := m.fs.readFile(path.Join(dir, "comm"))
+	if err != nil {
+		return nil, false
+	}
+	info := map[string]string{"comm": strings.TrimSpace(string(comm))}
+
+	for _, g := range m.config.Groups {
+		if !m.matches(g, dir, info) {
+			continue
+		}
+		vars := map[string]interface{}{}
+		for k, v := range captures {
+			vars[k] = v
+		}
+		vars["comm"] = info["comm"]
+		vars["group"] = g.Name
+		if m.config.CountFds {
+			fds, _ := m.fs.glob(path.Join(dir, "fd", "*"))
+			vars["fds"] = float64(len(fds))
+		}
+		return vars, true
+	}
+	return nil, false
+}
+
+func (m *processMatcher) matches(g processGroup, dir string, info map[string]string) bool {
+	checks := []struct {
+		name    string
+		pattern string
+	}{
+		{"comm", g.Comm},
+		{"cmdline", g.Cmdline},
+		{"exe", g.Exe},
+		{"user", g.User},
+	}
+	for _, check := range checks {
+		if check.pattern == "" {
+			continue
+		}
+		value, ok := info[check.name]
+		if !ok {
+			value = m.lookup(check.name, dir)
+			info[check.name] = value
+		}
+		pattern, err := compilePattern(check.pattern)
+		if err != nil || !pattern.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Lookup a property of the process. Missing or unreadable files, e.g. the exe
+// link for processes of other users, result in an empty value.
+func (m *processMatcher) lookup(name string, dir string) string {
+	switch name {
+	case "cmdline":
+		data, _ := m.fs.readFile(path.Join(dir, "cmdline"))
+		return strings.TrimSpace(strings.Replace(string(data), "\x00", " ", -1))
+	case "exe":
+		exe, _ := m.fs.readlink(path.Join(dir, "exe"))
+		return exe
+	case "user":
+		data, _ := m.fs.readFile(path.Join(dir, "status"))
+		uid := ""
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && fields[0] == "Uid:" {
+				uid = fields[1]
+				break
+			}
+		}
+		if user, ok := m.userNames()[uid]; ok {
+			return user
+		}
+		return uid
+	default:
+		return ""
+	}
+}
+
+// Map of uid to user name from /etc/passwd.
+func (m *processMatcher) userNames() map[string]string {
+	if m.users != nil {
+		return m.users
+	}
+	m.users = map[string]string{}
+	data, _ := m.fs.readFile("/etc/passwd")
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) >= 3 {
+			m.users[fields[2]] = fields[0]
+		}
+	}
+	return m.users
+}
+
+// Sum the values of the fields for the records of all processes in a group.
+// The records of each file are combined by position and the oldest
+// modification time is used for the group. Fields that are not summed are
+// dropped.
+func aggregateProcesses(files []fileRecords, sum map[string]bool) []fileRecords {
+	groups := map[string]*fileRecords{}
+	counts := map[string]int{}
+	for _, f := range files {
+		name := fmt.Sprintf("%v", f.captures["group"])
+		counts[name]++
+		g, ok := groups[name]
+		if !ok {
+			captures := map[string]interface{}{}
+			for k, v := range f.captures {
+				if k != pidVar && k != "comm" {
+					captures[k] = v
+				}
+			}
+			records := []map[string]interface{}{}
+			for _, r := range f.records {
+				records = append(records, sumRecord(r, sum))
+			}
+			groups[name] = &fileRecords{f.file, captures, f.mtime, records}
+			continue
+		}
+
+		if fds, ok := f.captures["fds"].(float64); ok {
+			g.captures["fds"] = g.captures["fds"].(float64) + fds
+		}
+		if f.mtime.Before(g.mtime) {
+			g.mtime = f.mtime
+		}
+		for i, r := range f.records {
+			if i >= len(g.records) {
+				g.records = append(g.records, sumRecord(r, sum))
+				continue
+			}
+			for k, v := range sumRecord(r, sum) {
+				total, ok1 := g.records[i][k].(float64)
+				value, ok2 := v.(float64)
+				if ok1 && ok2 {
+					g.records[i][k] = total + value
+				} else if _, ok := g.records[i][k]; !ok {
+					g.records[i][k] = v
+				}
+			}
+		}
+	}
+
+	names := []string{}
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := []fileRecords{}
+	for _, name := range names {
+		g := groups[name]
+		g.captures["processes"] = float64(counts[name])
+		result = append(result, *g)
+	}
+	return result
+}
+
+// Copy of a record with only the fields that are summed.
+func sumRecord(r map[string]interface{}, sum map[string]bool) map[string]interface{} {
+	result := map[string]interface{}{}
+	for k, v := range r {
+		if sum[k] {
+			result[k] = v
+		}
+	}
+	return result
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newProcessFileSystem() *memFileSystem {
+	now := time.Now()
+	fs := newMemFileSystem()
+	fs.add("/etc/passwd", []byte("root:x:0:0:root:/root:/bin/bash\nwww-data:x:33:33:www-data:/var/www:/usr/sbin/nologin\n"), now)
+	procs := []struct {
+		pid     int
+		comm    string
+		cmdline string
+		exe     string
+		uid     int
+		rss     int
+		fds     int
+	}{
+		{1, "systemd", "/sbin/init\x00splash\x00", "/lib/systemd/systemd", 0, 9000, 3},
+		{812, "nginx", "nginx: master process /usr/sbin/nginx\x00", "/usr/sbin/nginx", 0, 1500, 2},
+		{813, "nginx", "nginx: worker process\x00", "/usr/sbin/nginx", 33, 3500, 4},
+		{2048, "java", "/usr/bin/java\x00-jar\x00app.jar\x00", "/usr/lib/jvm/bin/java", 33, 250000, 1},
+	}
+	for _, p := range procs {
+		dir := fmt.Sprintf("/proc/%d", p.pid)
+		fs.add(dir+"/comm", []byte(p.comm+"\n"), now)
+		fs.add(dir+"/cmdline", []byte(p.cmdline), now)
+		fs.addLink(dir+"/exe", p.exe)
+		status := fmt.Sprintf("Name:\t%s\nUid:\t%d\t%d\t%d\t%d\nVmRSS:\t%d kB\nThreads:\t1\n", p.comm, p.uid, p.uid, p.uid, p.uid, p.rss)
+		fs.add(dir+"/status", []byte(status), now)
+		stat := fmt.Sprintf("%d (%s) S 1 %d %d 0 -1 4194560 100 0 2 0 %d 5 0 0 20 0 1 0 %d 1000 %d\n", p.pid, p.comm, p.pid, p.pid, p.pid, 1000+p.pid, p.rss/4)
+		fs.add(dir+"/stat", []byte(stat), now)
+		for i := 0; i < p.fds; i++ {
+			fs.addLink(fmt.Sprintf("%s/fd/%d", dir, i), "/dev/null")
+		}
+	}
+	fs.add("/proc/self/status", []byte("VmRSS:\t1 kB\n"), now)
+	return fs
+}
+
+func TestProcess(t *testing.T) {
+
+	newConfig := func() fileConfig {
+		return fileConfig{
+			File:   "/proc/{pid}/status",
+			Parser: newKeyValueConfig("\n\n", ""),
+			Process: &processConfig{
+				Groups: []processGroup{
+					{Name: "web", Comm: "^nginx$"},
+					{Name: "app", Cmdline: "-jar app.jar", User: "^www-data$"},
+				},
+				CountFds: true,
+			},
+			Metrics: map[string]metricConfig{
+				"/test/process/{group}/{pid}/rss": newMetricConfig("{VmRSS}"),
+			},
+			Tags:   map[string]string{"comm": "{comm}", "fds": "{fds}"},
+			source: "a.json entry 0",
+		}
+	}
+
+	collect := func(c fileConfig) map[string]interface{} {
+		_, err := c.getMetricTypes()
+		So(err, ShouldBeNil)
+		ctx := newCollectContext(log.New(), newProcessFileSystem())
+		ms, err := c.collectMetrics(ctx, nil)
+		So(err, ShouldBeNil)
+		So(ctx.errors, ShouldBeEmpty)
+		values := map[string]interface{}{}
+		for _, m := range ms {
+			values[m.Namespace().String()] = m.Data()
+			values[m.Namespace().String()+" tags"] = m.Tags()
+		}
+		return values
+	}
+
+	Convey("collect per process", t, func() {
+		values := collect(newConfig())
+		So(len(values), ShouldEqual, 6)
+		So(values["/test/process/web/812/rss"], ShouldEqual, 1500.0)
+		So(values["/test/process/web/813/rss"], ShouldEqual, 3500.0)
+		So(values["/test/process/app/2048/rss"], ShouldEqual, 250000.0)
+		So(values["/test/process/app/2048/rss tags"].(map[string]string)["comm"], ShouldEqual, "java")
+		So(values["/test/process/web/813/rss tags"].(map[string]string)["fds"], ShouldEqual, "4")
+	})
+
+	Convey("match on exe", t, func() {
+		c := newConfig()
+		c.Process.Groups = []processGroup{{Name: "init", Exe: "systemd$"}}
+		values := collect(c)
+		So(len(values), ShouldEqual, 2)
+		So(values["/test/process/init/1/rss"], ShouldEqual, 9000.0)
+	})
+
+	Convey("aggregate per group", t, func() {
+		c := newConfig()
+		c.Process.Aggregate = true
+		c.Metrics = map[string]metricConfig{
+			"/test/process/{group}/rss":       newMetricConfig("{VmRSS}"),
+			"/test/process/{group}/processes": newMetricConfig("{processes}"),
+		}
+		c.Tags = map[string]string{"fds": "{fds}"}
+		values := collect(c)
+		So(values["/test/process/web/rss"], ShouldEqual, 5000.0)
+		So(values["/test/process/web/processes"], ShouldEqual, 2.0)
+		So(values["/test/process/web/rss tags"].(map[string]string)["fds"], ShouldEqual, "6")
+		So(values["/test/process/app/processes"], ShouldEqual, 1.0)
+	})
+
+	Convey("aggregate only sums additive fields", t, func() {
+		c := newConfig()
+		c.File = "/proc/{pid}/stat"
+		c.Parser = newProcStatConfig()
+		c.Process.Aggregate = true
+		c.Metrics = map[string]metricConfig{
+			"/test/process/{group}/utime": newMetricConfig("{utime}"),
+			"/test/process/{group}/rss":   newMetricConfig("{rss}"),
+		}
+		c.Tags = map[string]string{}
+		values := collect(c)
+		So(values["/test/process/web/utime"], ShouldEqual, 812.0+813.0)
+		So(values["/test/process/web/rss"], ShouldEqual, 375.0+875.0)
+
+		pattern, err := newFilePattern(c.File)
+		So(err, ShouldBeNil)
+		files, err := c.loadFiles(newCollectContext(log.New(), newProcessFileSystem()), pattern, c.Parser)
+		So(err, ShouldBeNil)
+		So(len(files), ShouldEqual, 2)
+		for _, f := range files {
+			for _, r := range f.records {
+				So(r, ShouldNotContainKey, "pid")
+				So(r, ShouldNotContainKey, "ppid")
+				So(r, ShouldNotContainKey, "starttime")
+				So(r, ShouldNotContainKey, "comm")
+			}
+		}
+
+		c.Metrics["/test/process/{group}/start"] = newMetricConfig("{starttime}")
+		errs := validateFileConfig(setfileEntry{"a.json", 0, 2, c}, nil)
+		So(errorStrings(errs), ShouldResemble, []string{
+			"a.json:2: entry 0: metrics[/test/process/{group}/start]: variable 'starttime' is not produced by the parser",
+		})
+
+		c.Process.Sum = []string{"starttime"}
+		c.Metrics = map[string]metricConfig{"/test/process/{group}/start": newMetricConfig("{starttime}")}
+		values = collect(c)
+		So(values["/test/process/web/start"], ShouldEqual, 1812.0+1813.0)
+	})
+
+	Convey("validate", t, func() {
+		So(processConfig{}.validate().Error(), ShouldEqual, "at least one group must be specified")
+		So(processConfig{Groups: []processGroup{{Comm: "a"}}}.validate().Error(), ShouldEqual, "groups[0]: name must be specified")
+		So(processConfig{Groups: []processGroup{{Name: "a"}}}.validate().Error(), ShouldEqual,
+			"groups[0]: at least one of comm, cmdline, exe or user must be specified")
+		So(processConfig{Groups: []processGroup{{Name: "a", Comm: "a"}, {Name: "a", Comm: "b"}}}.validate().Error(), ShouldEqual,
+			"groups[1]: duplicate group name 'a'")
+
+		c := newConfig()
+		So(validateFileConfig(setfileEntry{"a.json", 0, 2, c}, nil), ShouldBeEmpty)
+
+		c.File = "/proc/*/status"
+		_, err := c.getMetricTypes()
+		So(err.Error(), ShouldEqual, "file pattern must capture the pid when using process, e.g. '/proc/{pid}/status': '/proc/*/status'")
+
+		c = newConfig()
+		c.Process.Aggregate = true
+		errs := validateFileConfig(setfileEntry{"a.json", 0, 2, c}, newProcessFileSystem())
+		So(errorStrings(errs), ShouldResemble, []string{
+			"a.json:2: entry 0: metrics[/test/process/{group}/{pid}/rss]: variable 'pid' is not produced by the parser",
+		})
+	})
+}
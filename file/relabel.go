@@ -0,0 +1,192 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/intelsdi-x/snap/control/plugin"
+)
+
+// Actions that can be used for a relabel rule.
+const (
+	// Replace matches of the pattern with the replacement, the replacement
+	// can refer to groups using '$1'.
+	relabelReplace = "replace"
+
+	// Keep the first length characters.
+	relabelTruncate = "truncate"
+
+	relabelLowercase = "lowercase"
+
+	// Replace with the hex encoded SHA-256 hash, truncated to length if
+	// greater than 0.
+	relabelHash = "hash"
+
+	// Map the value using the table. Values that are not in the table are
+	// mapped to the default if set, otherwise they are not changed.
+	relabelLookup = "lookup"
+
+	// Drop the metric if the value matches the pattern.
+	relabelDrop = "drop"
+)
+
+var relabelActions = []string{
+	relabelReplace,
+	relabelTruncate,
+	relabelLowercase,
+	relabelHash,
+	relabelLookup,
+	relabelDrop,
+}
+
+// Rule for changing the value of a dynamic namespace element or a tag after
+// the metric has been created. For example, to shorten container ids:
+//
+//	"relabel": [
+//	  {"element": "container", "action": "truncate", "length": 12}
+//	]
+type relabelRule struct {
+	// Name of the dynamic namespace element to change.
+	Element string `json:"element"`
+
+	// Key of the tag to change.
+	Tag string `json:"tag"`
+
+	Action      string            `json:"action"`
+	Pattern     string            `json:"pattern"`
+	Replacement string            `json:"replacement"`
+	Length      int               `json:"length"`
+	Table       map[string]string `json:"table"`
+	Default     string            `json:"default"`
+}
+
+func (r relabelRule) validate() error {
+	if (r.Element == "") == (r.Tag == "") {
+		return errors.New("exactly one of element or tag must be specified")
+	}
+	if !contains(relabelActions, r.Action) {
+		msg := fmt.Sprintf("unknown action: '%v', expected one of %v", r.Action, relabelActions)
+		return errors.New(msg)
+	}
+	switch r.Action {
+	case relabelReplace, relabelDrop:
+		if r.Pattern == "" {
+			return errors.New(fmt.Sprintf("pattern must be specified for action '%v'", r.Action))
+		}
+		if _, err := compilePattern(r.Pattern); err != nil {
+			return err
+		}
+	case relabelTruncate:
+		if r.Length <= 0 {
+			return errors.New("length must be greater than 0 for action 'truncate'")
+		}
+	case relabelLookup:
+		if len(r.Table) == 0 {
+			return errors.New("table must be specified for action 'lookup'")
+		}
+	}
+	return nil
+}
+
+// Apply the rule to a value. The second return value is false if the metric
+// should be dropped.
+func (r relabelRule) apply(value string) (string, bool) {
+	switch r.Action {
+	case relabelReplace:
+		pattern, _ := compilePattern(r.Pattern)
+		return pattern.ReplaceAllString(value, r.Replacement), true
+	case relabelTruncate:
+		if len(value) > r.Length {
+			return value[:r.Length], true
+		}
+		return value, true
+	case relabelLowercase:
+		return strings.ToLower(value), true
+	case relabelHash:
+		sum := sha256.Sum256([]byte(value))
+		hash := hex.EncodeToString(sum[:])
+		if r.Length > 0 && r.Length < len(hash) {
+			hash = hash[:r.Length]
+		}
+		return hash, true
+	case relabelLookup:
+		if v, ok := r.Table[value]; ok {
+			return v, true
+		}
+		if r.Default != "" {
+			return r.Default, true
+		}
+		return value, true
+	case relabelDrop:
+		pattern, _ := compilePattern(r.Pattern)
+		return value, !pattern.MatchString(value)
+	default:
+		return value, true
+	}
+}
+
+// Characters that are not allowed in snap namespace elements.
+var namespaceReplacer = strings.NewReplacer(
+	"(", "_", ")", "_", "[", "_", "]", "_", "{", "_", "}", "_",
+	" ", "_",
+	".", "_", ",", "_", ";", "_", "?", "_", "!", "_",
+	"|", "_", "\\", "_", "/", "_",
+	"^", "_",
+	"\"", "_", "`", "_", "'", "_",
+)
+
+// Replace characters that are not allowed in a namespace element.
+func sanitizeElement(value string) string {
+	return namespaceReplacer.Replace(value)
+}
+
+// Apply the rules to the dynamic namespace elements and tags of a metric.
+// The namespace elements are sanitized after the rules have been applied.
+// Returns false if the metric should be dropped.
+func relabel(rules []relabelRule, m *plugin.MetricType) bool {
+	ns := m.Namespace_
+	for _, r := range rules {
+		if r.Element != "" {
+			for i := range ns {
+				if ns[i].IsDynamic() && ns[i].Name == r.Element {
+					value, keep := r.apply(ns[i].Value)
+					if !keep {
+						return false
+					}
+					ns[i].Value = value
+				}
+			}
+		} else if v, ok := m.Tags_[r.Tag]; ok {
+			value, keep := r.apply(v)
+			if !keep {
+				return false
+			}
+			m.Tags_[r.Tag] = value
+		}
+	}
+
+	for i := range ns {
+		if ns[i].IsDynamic() {
+			ns[i].Value = sanitizeElement(ns[i].Value)
+		}
+	}
+	return true
+}
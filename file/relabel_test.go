@@ -0,0 +1,104 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRelabel(t *testing.T) {
+
+	Convey("apply", t, func() {
+		apply := func(r relabelRule, value string) string {
+			v, keep := r.apply(value)
+			So(keep, ShouldBeTrue)
+			return v
+		}
+		So(apply(relabelRule{Action: relabelReplace, Pattern: "^docker-(.*)\\.scope$", Replacement: "$1"}, "docker-abc.scope"), ShouldEqual, "abc")
+		So(apply(relabelRule{Action: relabelTruncate, Length: 3}, "abcdef"), ShouldEqual, "abc")
+		So(apply(relabelRule{Action: relabelTruncate, Length: 10}, "abcdef"), ShouldEqual, "abcdef")
+		So(apply(relabelRule{Action: relabelLowercase}, "Eth0"), ShouldEqual, "eth0")
+		So(apply(relabelRule{Action: relabelHash, Length: 8}, "abc"), ShouldEqual, "ba7816bf")
+		So(len(apply(relabelRule{Action: relabelHash}, "abc")), ShouldEqual, 64)
+
+		lookup := relabelRule{Action: relabelLookup, Table: map[string]string{"0": "user"}}
+		So(apply(lookup, "0"), ShouldEqual, "user")
+		So(apply(lookup, "1"), ShouldEqual, "1")
+		lookup.Default = "other"
+		So(apply(lookup, "1"), ShouldEqual, "other")
+
+		_, keep := relabelRule{Action: relabelDrop, Pattern: "^lo$"}.apply("lo")
+		So(keep, ShouldBeFalse)
+		_, keep = relabelRule{Action: relabelDrop, Pattern: "^lo$"}.apply("lo0")
+		So(keep, ShouldBeTrue)
+	})
+
+	Convey("validate", t, func() {
+		So(relabelRule{Element: "id", Action: relabelLowercase}.validate(), ShouldBeNil)
+		So(relabelRule{Action: relabelLowercase}.validate().Error(), ShouldEqual, "exactly one of element or tag must be specified")
+		So(relabelRule{Element: "id", Tag: "id", Action: relabelLowercase}.validate().Error(), ShouldEqual, "exactly one of element or tag must be specified")
+		So(relabelRule{Element: "id", Action: "upper"}.validate().Error(), ShouldEqual, "unknown action: 'upper', expected one of [replace truncate lowercase hash lookup drop]")
+		So(relabelRule{Element: "id", Action: relabelDrop}.validate().Error(), ShouldEqual, "pattern must be specified for action 'drop'")
+		So(relabelRule{Element: "id", Action: relabelTruncate}.validate().Error(), ShouldEqual, "length must be greater than 0 for action 'truncate'")
+		So(relabelRule{Element: "id", Action: relabelLookup}.validate().Error(), ShouldEqual, "table must be specified for action 'lookup'")
+	})
+
+	Convey("sanitizeElement", t, func() {
+		So(sanitizeElement("eth0"), ShouldEqual, "eth0")
+		So(sanitizeElement("docker-abc.scope"), ShouldEqual, "docker-abc_scope")
+		So(sanitizeElement("a/b (c)"), ShouldEqual, "a_b__c_")
+	})
+
+	Convey("relabel collected metrics", t, func() {
+		fs := newMemFileSystem()
+		fs.add("/cgroup/docker-ABCDEF.scope/usage", []byte("10\n"), time.Now())
+		fs.add("/cgroup/init.scope/usage", []byte("20\n"), time.Now())
+
+		c := fileConfig{
+			File:   "/cgroup/{id}/usage",
+			Parser: newTableConfig([]string{"value"}, 0),
+			Tags:   map[string]string{"scope": "{id}"},
+			Metrics: map[string]metricConfig{
+				"/test/{id}/usage": newMetricConfig("{value}"),
+			},
+			Relabel: []relabelRule{
+				{Element: "id", Action: relabelDrop, Pattern: "^init"},
+				{Element: "id", Action: relabelReplace, Pattern: "^docker-(.*)\\.scope$", Replacement: "$1"},
+				{Element: "id", Action: relabelLowercase},
+				{Tag: "scope", Action: relabelTruncate, Length: 6},
+			},
+			source: "a.json entry 0",
+		}
+		_, err := c.getMetricTypes()
+		So(err, ShouldBeNil)
+
+		ctx := newCollectContext(log.New(), fs)
+		ms, err := c.collectMetrics(ctx, nil)
+		So(err, ShouldBeNil)
+		So(ctx.errors, ShouldBeEmpty)
+		So(len(ms), ShouldEqual, 1)
+		So(ms[0].Namespace().String(), ShouldEqual, "/test/abcdef/usage")
+		So(ms[0].Tags()["scope"], ShouldEqual, "docker")
+
+		c.Relabel = []relabelRule{{Element: "id", Action: relabelTruncate}}
+		_, err = c.getMetricTypes()
+		So(err.Error(), ShouldEqual, "relabel[0]: length must be greater than 0 for action 'truncate'")
+	})
+}
@@ -0,0 +1,242 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/intelsdi-x/snap/control/plugin"
+	"github.com/intelsdi-x/snap/core"
+)
+
+// Valid set of file configs loaded from a setfile. A generation is never
+// modified after it has been created so it can be used by a collection
+// while a reload is in progress.
+type setfileGeneration struct {
+	number      int
+	hash        string
+	configs     []fileConfig
+	metricTypes []plugin.MetricType
+}
+
+// Keeps track of the current generation of a setfile. The setfile will be
+// loaded again if the content changes, but the new configs will only be used
+// if they are valid. Otherwise the previous generation will be kept.
+type setfileLoader struct {
+	location string
+
+	mu        sync.Mutex
+	current   *setfileGeneration
+	lastError error
+
+	// Files read by the last attempt. The setfile is only read again if the
+	// modification time or size of one of them changes.
+	watch *setfileWatch
+
+	// Hash of the content for the last attempt if it failed, so that the
+	// same error is only logged once.
+	failedHash string
+}
+
+func newSetfileLoader(location string) *setfileLoader {
+	return &setfileLoader{location: location}
+}
+
+// Check if the setfile has changed and reload it if needed. The returned
+// generation is the one that should be used, an error is only returned if
+// there is no valid generation.
+func (l *setfileLoader) reload() (*setfileGeneration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.watch != nil && !l.watch.changed() {
+		if l.current == nil {
+			return nil, l.lastError
+		}
+		return l.current, nil
+	}
+
+	gen, hash, err := l.load()
+	if err != nil {
+		repeated := l.lastError != nil && hash == l.failedHash && err.Error() == l.lastError.Error()
+		l.lastError = err
+		l.failedHash = hash
+		if l.current == nil {
+			return nil, err
+		}
+		if !repeated {
+			log.Errorf("failed to reload setfile %s, keeping generation %d: %v",
+				l.location, l.current.number, err)
+		}
+		return l.current, nil
+	}
+
+	// The content may have been changed back to the current generation
+	// after a failed attempt.
+	l.lastError = nil
+	l.failedHash = ""
+	if gen != nil {
+		l.current = gen
+		log.Infof("loaded setfile %s generation %d with %d file configs",
+			l.location, gen.number, len(gen.configs))
+	}
+	return l.current, nil
+}
+
+// Load a new generation of the setfile. If the content has not changed
+// since the current generation, then nil will be returned. The hash of the
+// content is returned if the parts could be read.
+func (l *setfileLoader) load() (*setfileGeneration, string, error) {
+	parts, watch, err := watchSetfileParts(l.location)
+	l.watch = watch
+	if err != nil {
+		return nil, "", err
+	}
+
+	hash := setfileHash(parts)
+	if l.current != nil && l.current.hash == hash {
+		return nil, hash, nil
+	}
+	if hash == l.failedHash {
+		return nil, hash, l.lastError
+	}
+
+	configs, err := configsFromParts(parts)
+	if err != nil {
+		return nil, hash, err
+	}
+	if err := checkNamespaceCollisions(configs); err != nil {
+		return nil, hash, err
+	}
+
+	metricTypes := []plugin.MetricType{}
+	for _, cfg := range configs {
+		mts, err := cfg.getMetricTypes()
+		if err != nil {
+			return nil, hash, cfg.sourceError(err)
+		}
+		metricTypes = append(metricTypes, mts...)
+	}
+
+	number := 1
+	if l.current != nil {
+		number = l.current.number + 1
+	}
+	return &setfileGeneration{
+		number:      number,
+		hash:        hash,
+		configs:     configs,
+		metricTypes: metricTypes,
+	}, hash, nil
+}
+
+// Error from the last reload attempt or nil if it was successful.
+func (l *setfileLoader) err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastError
+}
+
+// Namespaces for the metrics about the setfile itself. The generation is
+// incremented each time a changed setfile is loaded successfully and the
+// reload error is 1 if the last attempt failed, see the log for the error.
+var (
+	setfileGenerationNs  = core.NewNamespace(name, "setfile", "generation")
+	setfileReloadErrorNs = core.NewNamespace(name, "setfile", "reload_error")
+)
+
+// Check if a namespace pattern from the setfile could match one of the
+// metrics about the setfile. Returns the namespace of that metric.
+func reservedNamespace(pattern string) (string, bool) {
+	ns, err := toNamespace(pattern)
+	if err != nil {
+		return "", false
+	}
+	for _, reserved := range []core.Namespace{setfileGenerationNs, setfileReloadErrorNs} {
+		if len(*ns) != len(reserved) {
+			continue
+		}
+		matches := true
+		for i, e := range *ns {
+			if !e.IsDynamic() && e.Value != reserved[i].Value {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return reserved.String(), true
+		}
+	}
+	return "", false
+}
+
+func setfileMetricTypes() []plugin.MetricType {
+	return []plugin.MetricType{
+		{
+			Namespace_:   setfileGenerationNs,
+			Description_: "Generation of the setfile that is being used for collection.",
+		},
+		{
+			Namespace_:   setfileReloadErrorNs,
+			Description_: "1 if the last attempt to reload the setfile failed, otherwise 0.",
+		},
+	}
+}
+
+// Metrics for the state of the loader. They are only returned if included
+// in the queries.
+func (l *setfileLoader) metrics(queries []plugin.MetricType, timestamp time.Time) []plugin.MetricType {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	values := map[string]float64{
+		setfileGenerationNs.String():  0.0,
+		setfileReloadErrorNs.String(): 0.0,
+	}
+	if l.current != nil {
+		values[setfileGenerationNs.String()] = float64(l.current.number)
+	}
+	if l.lastError != nil {
+		values[setfileReloadErrorNs.String()] = 1.0
+	}
+
+	ms := []plugin.MetricType{}
+	for _, q := range queries {
+		ns := q.Namespace()
+		if v, ok := values[ns.String()]; ok {
+			ms = append(ms, *plugin.NewMetricType(ns, timestamp, map[string]string{}, "", v))
+			delete(values, ns.String())
+		}
+	}
+	return ms
+}
+
+// Hash of the names and content for all parts of a setfile.
+func setfileHash(parts []setfilePart) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part.file))
+		h.Write([]byte{0})
+		h.Write(part.data)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
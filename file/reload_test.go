@@ -0,0 +1,221 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin"
+	"github.com/intelsdi-x/snap/core"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSetfileLoader(t *testing.T) {
+
+	entry := func(ns string) string {
+		return "{\"file\": \"/proc/loadavg\", \"metrics\": {\"" + ns + "\": \"{1m}\"}, \"parser\": {\"format\": \"table\", \"columns\": [\"1m\"]}}"
+	}
+
+	Convey("reload only when the setfile changes", t, func() {
+		dir, err := ioutil.TempDir("", "reload")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		file := filepath.Join(dir, "setfile.json")
+		ioutil.WriteFile(file, []byte("["+entry("/test/load")+"]"), 0644)
+
+		loader := newSetfileLoader(file)
+		gen, err := loader.reload()
+		So(err, ShouldBeNil)
+		So(gen.number, ShouldEqual, 1)
+		So(len(gen.metricTypes), ShouldEqual, 1)
+
+		same, err := loader.reload()
+		So(err, ShouldBeNil)
+		So(same, ShouldEqual, gen)
+
+		ioutil.WriteFile(file, []byte("["+entry("/test/load")+", "+entry("/test/load2")+"]"), 0644)
+		gen, err = loader.reload()
+		So(err, ShouldBeNil)
+		So(gen.number, ShouldEqual, 2)
+		So(len(gen.metricTypes), ShouldEqual, 2)
+		So(loader.err(), ShouldBeNil)
+	})
+
+	Convey("keep previous generation if reload fails", t, func() {
+		dir, err := ioutil.TempDir("", "reload")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		file := filepath.Join(dir, "setfile.json")
+		ioutil.WriteFile(file, []byte("["+entry("/test/load")+"]"), 0644)
+
+		loader := newSetfileLoader(file)
+		gen, err := loader.reload()
+		So(err, ShouldBeNil)
+
+		// Collisions are only detected after parsing, so the invalid config
+		// must not replace the current generation.
+		ioutil.WriteFile(file, []byte("["+entry("/test/load")+", "+entry("/test/load")+"]"), 0644)
+		current, err := loader.reload()
+		So(err, ShouldBeNil)
+		So(current, ShouldEqual, gen)
+		So(loader.err().Error(), ShouldStartWith, "namespace collision: ")
+
+		ioutil.WriteFile(file, []byte("[{\"file\": "), 0644)
+		current, err = loader.reload()
+		So(err, ShouldBeNil)
+		So(current, ShouldEqual, gen)
+		So(loader.err(), ShouldNotBeNil)
+
+		ioutil.WriteFile(file, []byte("["+entry("/test/load2")+"]"), 0644)
+		current, err = loader.reload()
+		So(err, ShouldBeNil)
+		So(current.number, ShouldEqual, 2)
+		So(loader.err(), ShouldBeNil)
+	})
+
+	Convey("only read the setfile if it was modified", t, func() {
+		dir, err := ioutil.TempDir("", "reload")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		file := filepath.Join(dir, "setfile.json")
+		data := []byte("[" + entry("/test/load") + "]")
+		ioutil.WriteFile(file, data, 0644)
+		mtime := time.Unix(1470096000, 0)
+		os.Chtimes(file, mtime, mtime)
+
+		loader := newSetfileLoader(file)
+		gen, err := loader.reload()
+		So(err, ShouldBeNil)
+
+		// Same size and modification time, so it will not be parsed
+		invalid := make([]byte, len(data))
+		copy(invalid, data)
+		invalid[0] = '{'
+		ioutil.WriteFile(file, invalid, 0644)
+		os.Chtimes(file, mtime, mtime)
+		current, err := loader.reload()
+		So(err, ShouldBeNil)
+		So(current, ShouldEqual, gen)
+		So(loader.err(), ShouldBeNil)
+
+		os.Chtimes(file, mtime.Add(time.Second), mtime.Add(time.Second))
+		current, err = loader.reload()
+		So(err, ShouldBeNil)
+		So(current, ShouldEqual, gen)
+		So(loader.err(), ShouldNotBeNil)
+		So(loader.watch.changed(), ShouldBeFalse)
+
+		// Touching the file without changing the content is the same failure
+		lastError := loader.err()
+		os.Chtimes(file, mtime.Add(2*time.Second), mtime.Add(2*time.Second))
+		loader.reload()
+		So(loader.err().Error(), ShouldEqual, lastError.Error())
+
+		// Reverting to the content of the current generation clears the error
+		ioutil.WriteFile(file, data, 0644)
+		os.Chtimes(file, mtime.Add(3*time.Second), mtime.Add(3*time.Second))
+		current, err = loader.reload()
+		So(err, ShouldBeNil)
+		So(current, ShouldEqual, gen)
+		So(loader.err(), ShouldBeNil)
+	})
+
+	Convey("watch new files in a setfile directory", t, func() {
+		dir, err := ioutil.TempDir("", "reload")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		ioutil.WriteFile(filepath.Join(dir, "a.json"), []byte("["+entry("/test/a")+"]"), 0644)
+		loader := newSetfileLoader(dir)
+		gen, err := loader.reload()
+		So(err, ShouldBeNil)
+		So(len(gen.metricTypes), ShouldEqual, 1)
+
+		// Use an old modification time for the directory so that adding a
+		// file will change it, then read it again to update the watch.
+		mtime := time.Unix(1470096000, 0)
+		os.Chtimes(dir, mtime, mtime)
+		loader.watch = nil
+		loader.reload()
+
+		ioutil.WriteFile(filepath.Join(dir, "b.json"), []byte("["+entry("/test/b")+"]"), 0644)
+		gen, err = loader.reload()
+		So(err, ShouldBeNil)
+		So(len(gen.metricTypes), ShouldEqual, 2)
+	})
+
+	Convey("setfile metrics are reserved", t, func() {
+		_, ok := reservedNamespace("/file/setfile/generation")
+		So(ok, ShouldBeTrue)
+		ns, ok := reservedNamespace("/file/{kind}/reload_error")
+		So(ok, ShouldBeTrue)
+		So(ns, ShouldEqual, "/file/setfile/reload_error")
+		_, ok = reservedNamespace("/file/setfile/generation/count")
+		So(ok, ShouldBeFalse)
+
+		c := fileConfig{
+			File:    "/proc/loadavg",
+			Metrics: map[string]metricConfig{"/file/{name}/generation": newMetricConfig("1")},
+			Parser:  newTableConfig([]string{"1m"}, 0),
+			source:  "a.json entry 0",
+		}
+		err := checkNamespaceCollisions([]fileConfig{c})
+		So(err.Error(), ShouldEqual, "namespace collision: a.json entry 0 metric '/file/{name}/generation' has the same namespace as the plugin metric '/file/setfile/generation'")
+
+		errs := validateDuplicates([]setfileEntry{{"a.json", 0, 2, c}})
+		So(errorStrings(errs), ShouldResemble, []string{
+			"a.json:2: entry 0: metrics[/file/{name}/generation]: namespace is reserved for the plugin metric '/file/setfile/generation'",
+		})
+	})
+
+	Convey("setfile metrics", t, func() {
+		queries := setfileMetricTypes()
+		queries = append(queries, plugin.MetricType{Namespace_: core.NewNamespace("test", "load")})
+		now := time.Unix(1470096000, 0)
+
+		loader := newSetfileLoader("testdata/collision.json")
+		loader.reload()
+		values := map[string]interface{}{}
+		for _, m := range loader.metrics(queries, now) {
+			values[m.Namespace().String()] = m.Data()
+			So(m.Timestamp(), ShouldResemble, now)
+		}
+		So(values, ShouldResemble, map[string]interface{}{
+			"/file/setfile/generation":   0.0,
+			"/file/setfile/reload_error": 1.0,
+		})
+
+		loader = newSetfileLoader("testdata/fileconfig.json")
+		loader.reload()
+		ms := loader.metrics(queries[:1], now)
+		So(len(ms), ShouldEqual, 1)
+		So(ms[0].Data(), ShouldEqual, 1.0)
+	})
+
+	Convey("fail if there is no valid generation", t, func() {
+		loader := newSetfileLoader("testdata/collision.json")
+		_, err := loader.reload()
+		So(err, ShouldNotBeNil)
+		So(loader.err(), ShouldEqual, err)
+	})
+}
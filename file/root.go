@@ -0,0 +1,123 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	rootModePrefix  = "prefix"
+	rootModeOverlay = "overlay"
+)
+
+// Root directory used to access the files, for example when running in a
+// container with the host /proc and /sys mounted under /host. File patterns
+// in the setfile are written for the un-prefixed paths and the paths
+// returned by glob are also un-prefixed so that path captures and indices
+// are the same regardless of the root.
+type fileRoot struct {
+	fs     fileSystem
+	prefix string
+
+	// If true, then files that cannot be found under the prefix will be
+	// read from the real path.
+	overlay bool
+}
+
+// Create a file system that applies the root prefix to the paths of the
+// underlying file system. If the prefix is empty, then the underlying file
+// system will be returned as is.
+func newFileRoot(fs fileSystem, prefix string, mode string) (fileSystem, error) {
+	overlay := false
+	switch mode {
+	case "", rootModePrefix:
+	case rootModeOverlay:
+		overlay = true
+	default:
+		msg := fmt.Sprintf("unknown root mode: '%v'", mode)
+		return nil, errors.New(msg)
+	}
+
+	if prefix == "" {
+		return fs, nil
+	}
+	return fileRoot{fs, prefix, overlay}, nil
+}
+
+// In overlay mode the real root is only used if there are no matches under
+// the prefix. Combining both could mix files from different hosts or
+// containers, e.g. the pids for /proc/*/stat, and it is consistent with
+// readFile and stat that only fall back if the file does not exist.
+func (r fileRoot) glob(pattern string) ([]string, error) {
+	matches, err := r.fs.glob(filepath.Join(r.prefix, pattern))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matches) == 0 && r.overlay {
+		return r.fs.glob(pattern)
+	}
+
+	result := []string{}
+	for _, m := range matches {
+		result = append(result, r.strip(m, pattern))
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// Remove the prefix from a path found with glob. Relative patterns stay
+// relative.
+func (r fileRoot) strip(file string, pattern string) string {
+	rel, err := filepath.Rel(r.prefix, file)
+	if err != nil {
+		return file
+	}
+	if strings.HasPrefix(pattern, "/") {
+		return "/" + rel
+	}
+	return rel
+}
+
+func (r fileRoot) readFile(name string) ([]byte, error) {
+	data, err := r.fs.readFile(filepath.Join(r.prefix, name))
+	if err != nil && r.overlay && os.IsNotExist(err) {
+		return r.fs.readFile(name)
+	}
+	return data, err
+}
+
+func (r fileRoot) stat(name string) (os.FileInfo, error) {
+	info, err := r.fs.stat(filepath.Join(r.prefix, name))
+	if err != nil && r.overlay && os.IsNotExist(err) {
+		return r.fs.stat(name)
+	}
+	return info, err
+}
+
+func (r fileRoot) readlink(name string) (string, error) {
+	target, err := r.fs.readlink(filepath.Join(r.prefix, name))
+	if err != nil && r.overlay && os.IsNotExist(err) {
+		return r.fs.readlink(name)
+	}
+	return target, err
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFileRoot(t *testing.T) {
+
+	Convey("newFileRoot", t, func() {
+		fs := osFileSystem{}
+
+		r, err := newFileRoot(fs, "", "")
+		So(err, ShouldBeNil)
+		So(r, ShouldResemble, fs)
+
+		r, err = newFileRoot(fs, "/host", "")
+		So(err, ShouldBeNil)
+		So(r, ShouldResemble, fileRoot{fs, "/host", false})
+
+		r, err = newFileRoot(fs, "/host", "overlay")
+		So(err, ShouldBeNil)
+		So(r, ShouldResemble, fileRoot{fs, "/host", true})
+
+		_, err = newFileRoot(fs, "/host", "foo")
+		So(err.Error(), ShouldEqual, "unknown root mode: 'foo'")
+	})
+
+	Convey("glob with prefix", t, func() {
+		r, _ := newFileRoot(osFileSystem{}, "testdata/cgroup", "prefix")
+		files, err := r.glob("/cpu/*/cpu.shares")
+		So(err, ShouldBeNil)
+		So(files, ShouldResemble, []string{
+			"/cpu/0/cpu.shares",
+			"/cpu/1/cpu.shares",
+			"/cpu/2/cpu.shares",
+		})
+
+		data, err := r.readFile(files[0])
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "1024")
+
+		files, err = r.glob("testdata/loadavg")
+		So(err, ShouldBeNil)
+		So(files, ShouldResemble, []string{})
+	})
+
+	Convey("glob with overlay", t, func() {
+		r, _ := newFileRoot(osFileSystem{}, "testdata/cgroup", "overlay")
+		files, err := r.glob("testdata/loadavg")
+		So(err, ShouldBeNil)
+		So(files, ShouldResemble, []string{"testdata/loadavg"})
+
+		_, err = r.readFile(files[0])
+		So(err, ShouldBeNil)
+
+		_, err = r.stat(files[0])
+		So(err, ShouldBeNil)
+	})
+
+	Convey("root over snapshot", t, func() {
+		fs := newMemFileSystem()
+		fs.add("/host/proc/loadavg", []byte("0.01 0.05 0.05 1/461 13282"), time.Unix(0, 0))
+		fs.add("/proc/stat", []byte("cpu 1 2 3"), time.Unix(0, 0))
+
+		r, _ := newFileRoot(fs, "/host", "prefix")
+		files, err := r.glob("/proc/*")
+		So(err, ShouldBeNil)
+		So(files, ShouldResemble, []string{"/proc/loadavg"})
+
+		r, _ = newFileRoot(fs, "/host", "overlay")
+		files, err = r.glob("/proc/*")
+		So(err, ShouldBeNil)
+		So(files, ShouldResemble, []string{"/proc/loadavg"})
+
+		files, err = r.glob("/proc/stat")
+		So(err, ShouldBeNil)
+		So(files, ShouldResemble, []string{"/proc/stat"})
+
+		data, err := r.readFile("/proc/stat")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "cpu 1 2 3")
+	})
+
+	Convey("overlay does not mix prefixed and real matches", t, func() {
+		fs := newMemFileSystem()
+		fs.add("/host/proc/1/stat", []byte("1 (init) S"), time.Unix(0, 0))
+		fs.add("/host/proc/42/stat", []byte("42 (java) S"), time.Unix(0, 0))
+		fs.add("/proc/1/stat", []byte("1 (sh) S"), time.Unix(0, 0))
+		fs.add("/proc/7/stat", []byte("7 (sleep) S"), time.Unix(0, 0))
+
+		r, _ := newFileRoot(fs, "/host", "overlay")
+		files, err := r.glob("/proc/*/stat")
+		So(err, ShouldBeNil)
+		So(files, ShouldResemble, []string{"/proc/1/stat", "/proc/42/stat"})
+
+		data, err := r.readFile(files[0])
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "1 (init) S")
+	})
+}
@@ -0,0 +1,568 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Top level structure of a setfile. For compatibility a list of file configs
+// is also accepted and treated as a document with only the files section.
+type setfileDoc struct {
+	Files []json.RawMessage `json:"files"`
+
+	// Other setfiles to load. Relative paths are resolved against the
+	// directory of the setfile and can be directories or globs.
+	Include []string `json:"include"`
+
+	// Named parser configs that can be referenced by the file configs
+	// instead of repeating the parser block.
+	Parsers map[string]json.RawMessage `json:"parsers"`
+
+	// Named templates that can be extended by the file configs, see
+	// templateConfig.
+	Templates map[string]json.RawMessage `json:"templates"`
+}
+
+func (d *setfileDoc) UnmarshalJSON(data []byte) error {
+	if isJsonList(data) {
+		return json.Unmarshal(data, &d.Files)
+	}
+	type plain setfileDoc
+	return json.Unmarshal(data, (*plain)(d))
+}
+
+func isJsonList(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) > 0 && data[0] == '['
+}
+
+const (
+	formatJson = "json"
+	formatYaml = "yaml"
+	formatToml = "toml"
+)
+
+var tomlLine = regexp.MustCompile("(?m)^\\s*(\\[\\[?[A-Za-z0-9_.\"-]+\\]\\]?|[A-Za-z0-9_\"-]+\\s*=)")
+
+// Determine the format of a setfile based on the extension. If the extension
+// is not known, then the content will be used to guess the format.
+func setfileFormat(file string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		return formatJson
+	case ".yaml", ".yml":
+		return formatYaml
+	case ".toml":
+		return formatToml
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') && json.Valid(trimmed):
+		return formatJson
+	case tomlLine.Match(data):
+		return formatToml
+	default:
+		return formatYaml
+	}
+}
+
+// Convert the contents of a setfile to JSON so that the same structs can be
+// used for all formats. The lines are the line numbers in the original file
+// for each of the file configs, or nil if they are not known.
+func setfileToJson(file string, data []byte) ([]byte, []int, error) {
+	switch setfileFormat(file, data) {
+	case formatYaml:
+		return yamlToJson(file, data)
+	case formatToml:
+		return tomlToJson(file, data)
+	default:
+		lines, err := jsonEntryLines(data)
+		if err != nil {
+			if se, ok := err.(*json.SyntaxError); ok {
+				msg := fmt.Sprintf("%s:%d: %v", file, lineNumber(data, se.Offset), err)
+				return nil, nil, errors.New(msg)
+			}
+			return nil, nil, errors.New(fmt.Sprintf("%s: %v", file, err))
+		}
+		return data, lines, nil
+	}
+}
+
+// Find the line numbers for each of the file configs in a JSON setfile.
+func jsonEntryLines(data []byte) ([]int, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	tok, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	list := func() ([]int, error) {
+		lines := []int{}
+		for decoder.More() {
+			lines = append(lines, lineNumber(data, skipSpace(data, decoder.InputOffset())))
+			if err := decoder.Decode(&json.RawMessage{}); err != nil {
+				return nil, err
+			}
+		}
+		return lines, nil
+	}
+
+	switch tok {
+	case json.Delim('['):
+		return list()
+	case json.Delim('{'):
+		lines := []int{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			if key == "files" {
+				if tok, err := decoder.Token(); err != nil || tok != json.Delim('[') {
+					return nil, errors.New("files must be a list of file configs")
+				}
+				if lines, err = list(); err != nil {
+					return nil, err
+				}
+				if _, err := decoder.Token(); err != nil {
+					return nil, err
+				}
+			} else if err := decoder.Decode(&json.RawMessage{}); err != nil {
+				return nil, err
+			}
+		}
+		return lines, nil
+	default:
+		return nil, errors.New("setfile must be a list of file configs or an object")
+	}
+}
+
+// YAML setfiles can use anchors and aliases, including merge keys, to share
+// common blocks such as the parser config.
+func yamlToJson(file string, data []byte) ([]byte, []int, error) {
+	root := yaml.Node{}
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("%s: %v", file, err))
+	}
+	if len(root.Content) == 0 {
+		return []byte("[]"), []int{}, nil
+	}
+
+	doc := root.Content[0]
+	files := doc
+	if doc.Kind == yaml.MappingNode {
+		files = nil
+		for i := 0; i+1 < len(doc.Content); i += 2 {
+			if doc.Content[i].Value == "files" {
+				files = doc.Content[i+1]
+			}
+		}
+	}
+
+	lines := []int{}
+	if files != nil && files.Kind == yaml.SequenceNode {
+		for _, n := range files.Content {
+			lines = append(lines, n.Line)
+		}
+	}
+
+	var value interface{}
+	if err := doc.Decode(&value); err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("%s: %v", file, err))
+	}
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		msg := fmt.Sprintf("%s:%d: %v", file, doc.Line, err)
+		return nil, nil, errors.New(msg)
+	}
+	return jsonData, lines, nil
+}
+
+// TOML requires the top level to be a table so the file configs must be
+// specified as an array of tables named files, i.e. [[files]].
+func tomlToJson(file string, data []byte) ([]byte, []int, error) {
+	value := map[string]interface{}{}
+	if _, err := toml.Decode(string(data), &value); err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("%s: %v", file, err))
+	}
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("%s: %v", file, err))
+	}
+
+	// The lines are only used if there is a [[files]] header for each entry,
+	// i.e. not for an inline array such as files = [{...}].
+	doc := struct {
+		Files []json.RawMessage `json:"files"`
+	}{}
+	json.Unmarshal(jsonData, &doc)
+	lines := tomlEntryLines(data)
+	if len(lines) != len(doc.Files) {
+		lines = nil
+	}
+	return jsonData, lines, nil
+}
+
+var tomlFilesHeader = regexp.MustCompile("^\\s*\\[\\[\\s*(files|\"files\"|'files')\\s*\\]\\]")
+
+// Find the line numbers of the [[files]] headers in a TOML setfile. Lines
+// within multi-line strings are ignored.
+func tomlEntryLines(data []byte) []int {
+	lines := []int{}
+	quote := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		if quote != "" {
+			if strings.Count(line, quote)%2 == 1 {
+				quote = ""
+			}
+			continue
+		}
+		if tomlFilesHeader.MatchString(line) {
+			lines = append(lines, i+1)
+		}
+		for _, q := range []string{"\"\"\"", "'''"} {
+			if strings.Count(line, q)%2 == 1 {
+				quote = q
+			}
+		}
+	}
+	return lines
+}
+
+// Single file that is part of a setfile after expanding directories and
+// includes. The data has been converted to JSON.
+type setfilePart struct {
+	file  string
+	data  []byte
+	lines []int
+
+	// Contents of the file before it was converted to JSON, used to find
+	// the lines of the named parsers and templates.
+	source []byte
+}
+
+// Extensions of the files that will be loaded when the setfile is a
+// directory.
+var setfileExtensions = map[string]bool{
+	".json": true,
+	".yaml": true,
+	".yml":  true,
+	".toml": true,
+}
+
+// Expand a setfile location to a list of files. The location can be a file,
+// a directory, or a glob. For a directory all files with a known extension
+// are used. The files are sorted by name so the order is well defined.
+func expandSetfile(location string) ([]string, error) {
+	info, err := os.Stat(location)
+	if err == nil && !info.IsDir() {
+		return []string{location}, nil
+	}
+
+	files := []string{}
+	if err == nil {
+		entries, err := ioutil.ReadDir(location)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if !e.IsDir() && setfileExtensions[strings.ToLower(filepath.Ext(e.Name()))] {
+				files = append(files, filepath.Join(location, e.Name()))
+			}
+		}
+	} else {
+		matches, globErr := filepath.Glob(location)
+		if globErr != nil {
+			return nil, globErr
+		}
+		if len(matches) == 0 {
+			return nil, err
+		}
+		for _, m := range matches {
+			if info, err := os.Stat(m); err == nil && !info.IsDir() {
+				files = append(files, m)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Files and directories that were read when loading a setfile, along with the
+// modification time and size from before they were read. It is used to check
+// if a setfile has changed without reading and parsing all of the parts.
+type setfileWatch struct {
+	paths  []string
+	stamps []string
+}
+
+func fileStamp(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "missing"
+	}
+	return fmt.Sprintf("%d %d", info.ModTime().UnixNano(), info.Size())
+}
+
+func (w *setfileWatch) add(path string) {
+	w.paths = append(w.paths, path)
+	w.stamps = append(w.stamps, fileStamp(path))
+}
+
+// Check if any of the files or directories have changed since they were
+// read.
+func (w *setfileWatch) changed() bool {
+	for i, path := range w.paths {
+		if fileStamp(path) != w.stamps[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// Load all parts of a setfile. The entries of a file come before the entries
+// of the files it includes, and includes are loaded in the order listed. A
+// file that is reached more than once, e.g. templates shared by several
+// parts, is only loaded the first time. It is an error if a file includes
+// itself directly or through other files.
+func loadSetfileParts(location string) ([]setfilePart, error) {
+	parts, _, err := watchSetfileParts(location)
+	return parts, err
+}
+
+// Same as loadSetfileParts, but also returns the files that were read. The
+// watch is returned even if there is an error so that a broken setfile will
+// not be read again until it is modified.
+func watchSetfileParts(location string) ([]setfilePart, *setfileWatch, error) {
+	parts := []setfilePart{}
+	loaded := map[string]bool{}
+	stack := []string{}
+	watch := &setfileWatch{}
+
+	var load func(location string, from string) error
+	load = func(location string, from string) error {
+		// For a directory the modification time changes when files are
+		// added or removed. If it is a glob, then use the directory
+		// containing the pattern.
+		watch.add(location)
+		if _, err := os.Stat(location); err != nil {
+			watch.add(filepath.Dir(location))
+		}
+
+		files, err := expandSetfile(location)
+		if err != nil {
+			if from != "" {
+				return errors.New(fmt.Sprintf("%s: include: %v", from, err))
+			}
+			return err
+		}
+
+		for _, file := range files {
+			abs, err := filepath.Abs(file)
+			if err != nil {
+				return err
+			}
+			for i, f := range stack {
+				if f == abs {
+					cycle := strings.Join(append(stack[i:], abs), " -> ")
+					return errors.New(fmt.Sprintf("%s: include cycle: %s", from, cycle))
+				}
+			}
+			if loaded[abs] {
+				continue
+			}
+			loaded[abs] = true
+
+			watch.add(file)
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			jsonData, lines, err := setfileToJson(file, data)
+			if err != nil {
+				return err
+			}
+			parts = append(parts, setfilePart{file, jsonData, lines, data})
+
+			doc := setfileDoc{}
+			if err := json.Unmarshal(jsonData, &doc); err != nil {
+				return errors.New(fmt.Sprintf("%s: %v", file, err))
+			}
+			stack = append(stack, abs)
+			for _, include := range doc.Include {
+				if !filepath.IsAbs(include) {
+					include = filepath.Join(filepath.Dir(file), include)
+				}
+				if err := load(include, file); err != nil {
+					return err
+				}
+			}
+			stack = stack[:len(stack)-1]
+		}
+		return nil
+	}
+
+	if err := load(location, ""); err != nil {
+		return nil, watch, err
+	}
+	return parts, watch, nil
+}
+
+// Load the file configs from a setfile in any of the supported formats. The
+// setfile can also be a directory or glob and use includes, see
+// loadSetfileParts for the order the configs will be in.
+func loadSetfile(location string) (*[]fileConfig, error) {
+	parts, err := loadSetfileParts(location)
+	if err != nil {
+		return nil, err
+	}
+	configs, err := configsFromParts(parts)
+	if err != nil {
+		return nil, err
+	}
+	return &configs, nil
+}
+
+// Create the file configs for the parts of a setfile.
+func configsFromParts(parts []setfilePart) ([]fileConfig, error) {
+	shared, errs := sharedDefinitions(parts)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	configs := []fileConfig{}
+	for _, part := range parts {
+		cs, err := fromJsonWith(part.data, shared)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s: %v", part.file, err))
+		}
+		for i, c := range *cs {
+			c.source = entrySource(part.file, part.lines, i)
+			configs = append(configs, c)
+		}
+		log.Debugf("loaded %d file configs from %s", len(*cs), part.file)
+	}
+	return configs, nil
+}
+
+// Description of where an entry was loaded from, including the line number
+// if known.
+func entrySource(file string, lines []int, i int) string {
+	if i < len(lines) {
+		return fmt.Sprintf("%s:%d entry %d", file, lines[i], i)
+	}
+	return fmt.Sprintf("%s entry %d", file, i)
+}
+
+// Collect the named parsers and templates of all parts of a setfile so that
+// an entry can use a name defined in any of the parts, e.g. a file of shared
+// templates that is included by several setfiles. It is an error if the same
+// name is defined in more than one part.
+func sharedDefinitions(parts []setfilePart) (*setfileDoc, []error) {
+	shared := &setfileDoc{
+		Parsers:   map[string]json.RawMessage{},
+		Templates: map[string]json.RawMessage{},
+	}
+	parsers := map[string]string{}
+	templates := map[string]string{}
+
+	errs := []error{}
+	define := func(kind string, part setfilePart, defs map[string]json.RawMessage, into map[string]json.RawMessage, seen map[string]string) {
+		for _, name := range sortedKeys(defs) {
+			where := definitionSource(part, kind+"s", name)
+			if prev, ok := seen[name]; ok {
+				errs = append(errs, errors.New(fmt.Sprintf("%s: %s '%v' is already defined at %s", where, kind, name, prev)))
+				continue
+			}
+			seen[name] = where
+			into[name] = defs[name]
+		}
+	}
+	for _, part := range parts {
+		doc := setfileDoc{}
+		if err := json.Unmarshal(part.data, &doc); err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("%s: %v", part.file, err)))
+			continue
+		}
+		define("parser", part, doc.Parsers, shared.Parsers, parsers)
+		define("template", part, doc.Templates, shared.Templates, templates)
+	}
+	return shared, errs
+}
+
+// Description of where a named parser or template is defined, including the
+// line number if it can be found.
+func definitionSource(part setfilePart, section string, name string) string {
+	if line := definitionLine(part.source, section, name); line > 0 {
+		return fmt.Sprintf("%s:%d", part.file, line)
+	}
+	return part.file
+}
+
+// Find the line of a named parser or template in the original contents of a
+// setfile. This is the first line after the start of the section that has
+// the name as a key, which works for the JSON, YAML and TOML formats.
+func definitionLine(source []byte, section string, name string) int {
+	keys := []string{
+		"\"" + name + "\"",
+		"'" + name + "'",
+		name + "\"",
+		name + "'",
+		name + ":",
+		name + " =",
+		name + "=",
+	}
+	header := "[" + section + "." + name + "]"
+
+	inSection := false
+	for i, line := range strings.Split(string(source), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, header) {
+			return i + 1
+		}
+		if !inSection {
+			inSection = strings.Contains(line, section)
+			if !inSection {
+				continue
+			}
+			// The first definition may be on the same line, e.g.
+			// {"templates": {"a": {...}}}.
+			line = line[strings.Index(line, section)+len(section):]
+			line = strings.TrimLeft(line, "\"': ={")
+		}
+		for _, key := range keys {
+			if strings.HasPrefix(line, key) {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,249 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// Clear the sources so configs loaded from different files can be compared.
+func withoutSource(configs []fileConfig) []fileConfig {
+	result := []fileConfig{}
+	for _, c := range configs {
+		c.source = ""
+		result = append(result, c)
+	}
+	return result
+}
+
+func TestSetfile(t *testing.T) {
+
+	Convey("setfileFormat", t, func() {
+		So(setfileFormat("a.json", nil), ShouldEqual, formatJson)
+		So(setfileFormat("a.yaml", nil), ShouldEqual, formatYaml)
+		So(setfileFormat("a.YML", nil), ShouldEqual, formatYaml)
+		So(setfileFormat("a.toml", nil), ShouldEqual, formatToml)
+
+		So(setfileFormat("setfile", []byte(" [{\"file\": \"a\"}]")), ShouldEqual, formatJson)
+		So(setfileFormat("setfile", []byte("{\"files\": []}")), ShouldEqual, formatJson)
+		So(setfileFormat("setfile", []byte("# comment\n[[files]]\nfile = \"a\"")), ShouldEqual, formatToml)
+		So(setfileFormat("setfile", []byte("files:\n  - file: a\n")), ShouldEqual, formatYaml)
+		So(setfileFormat("setfile", []byte("- {file: a}\n")), ShouldEqual, formatYaml)
+	})
+
+	Convey("jsonEntryLines", t, func() {
+		lines, err := jsonEntryLines([]byte("[\n  {},\n\n  {}\n]"))
+		So(err, ShouldBeNil)
+		So(lines, ShouldResemble, []int{2, 4})
+
+		lines, err = jsonEntryLines([]byte("{\n\"other\": [1, 2],\n\"files\": [\n{}, {}]}"))
+		So(err, ShouldBeNil)
+		So(lines, ShouldResemble, []int{4, 4})
+	})
+
+	Convey("load json", t, func() {
+		configs, err := loadSetfile("testdata/docker.json")
+		So(err, ShouldBeNil)
+		So(len(*configs), ShouldEqual, 13)
+		So((*configs)[1].source, ShouldEqual, "testdata/docker.json:18 entry 1")
+	})
+
+	Convey("load yaml", t, func() {
+		expected, err := loadSetfile("testdata/docker.json")
+		So(err, ShouldBeNil)
+
+		configs, err := loadSetfile("testdata/docker.yaml")
+		So(err, ShouldBeNil)
+		So(withoutSource(*configs), ShouldResemble, withoutSource(*expected))
+		So((*configs)[1].source, ShouldEqual, "testdata/docker.yaml:16 entry 1")
+	})
+
+	Convey("load toml", t, func() {
+		expected, err := loadSetfile("testdata/fileconfig.json")
+		So(err, ShouldBeNil)
+
+		configs, err := loadSetfile("testdata/fileconfig.toml")
+		So(err, ShouldBeNil)
+		So(withoutSource(*configs), ShouldResemble, withoutSource(*expected))
+		So((*configs)[1].source, ShouldEqual, "testdata/fileconfig.toml:17 entry 1")
+	})
+
+	Convey("toml entry lines", t, func() {
+		data := "[[files]]\nfile = \"a\"\ndesc = \"\"\"\n[[files]]\n\"\"\"\n\n  [[ files ]]\nfile = \"b\"\n"
+		_, lines, err := setfileToJson("a.toml", []byte(data))
+		So(err, ShouldBeNil)
+		So(lines, ShouldResemble, []int{1, 7})
+
+		_, lines, err = setfileToJson("a.toml", []byte("files = [{file = \"a\"}, {file = \"b\"}]\n"))
+		So(err, ShouldBeNil)
+		So(lines, ShouldBeNil)
+	})
+
+	Convey("load templates and named parsers", t, func() {
+		expected, err := loadSetfile("testdata/docker.json")
+		So(err, ShouldBeNil)
+
+		configs, err := loadSetfile("testdata/docker-templates.json")
+		So(err, ShouldBeNil)
+		So(withoutSource(*configs), ShouldResemble, withoutSource(*expected))
+		So(validateSetfile("testdata/docker-templates.json", nil), ShouldBeEmpty)
+	})
+
+	Convey("template fields can be overridden", t, func() {
+		doc := "{\"templates\": {\"t\": {\"file\": \"/proc/loadavg\", \"tags\": {\"a\": \"1\", \"b\": \"2\"}, \"parser\": {\"format\": \"table\", \"columns\": [\"1m\"]}}}, " +
+			"\"files\": [{\"template\": \"t\", \"file\": \"/proc/other\", \"metrics\": {\"/test/load\": \"{1m}\"}, \"tags\": {\"b\": \"3\"}}]}"
+		configs, err := fromJson([]byte(doc))
+		So(err, ShouldBeNil)
+		c := (*configs)[0]
+		So(c.File, ShouldEqual, "/proc/other")
+		So(c.Tags, ShouldResemble, map[string]string{"a": "1", "b": "3"})
+		So(c.Parser.Columns, ShouldResemble, []string{"1m"})
+	})
+
+	Convey("unknown templates and parsers", t, func() {
+		_, err := fromJson([]byte("{\"files\": [{\"template\": \"missing\"}]}"))
+		So(err.Error(), ShouldEqual, "entry 0: unknown template 'missing'")
+
+		_, err = fromJson([]byte("{\"files\": [{\"parser\": \"missing\"}]}"))
+		So(err.Error(), ShouldEqual, "entry 0: unknown parser 'missing'")
+
+		_, errs := validateJson("a.json", []byte("{\"parsers\": {\"p\": {\"formt\": \"table\"}}, \"templates\": {\"t\": {\"prefix\": \"/\"}}, \"files\": [{\"parser\": \"q\"}]}"), []int{2})
+		So(errorStrings(errs), ShouldResemble, []string{
+			"a.json: parsers[p].formt: unknown field",
+			"a.json: templates[t].prefix: unknown field",
+			"a.json:2: entry 0: unknown parser 'q'",
+		})
+	})
+
+	Convey("errors include line numbers", t, func() {
+		_, _, err := setfileToJson("a.json", []byte("[\n  {\"file\": \"x\",,}\n]"))
+		So(err.Error(), ShouldEqual, "a.json:2: invalid character ',' looking for beginning of object key string")
+
+		_, _, err = setfileToJson("a.yaml", []byte("files:\n  - file: x\n  bad\n"))
+		So(err.Error(), ShouldEqual, "a.yaml: yaml: line 3: could not find expected ':'")
+
+		_, _, err = setfileToJson("a.toml", []byte("[[files]]\nfile = \"x\"\nbad = ]\n"))
+		So(err.Error(), ShouldStartWith, "a.toml: toml: line 3")
+	})
+
+	Convey("expandSetfile", t, func() {
+		files, err := expandSetfile("testdata/docker.json")
+		So(err, ShouldBeNil)
+		So(files, ShouldResemble, []string{"testdata/docker.json"})
+
+		files, err = expandSetfile("testdata/conf.d")
+		So(err, ShouldBeNil)
+		So(files, ShouldResemble, []string{"testdata/conf.d/10-load.json", "testdata/conf.d/20-cpu.yaml"})
+
+		files, err = expandSetfile("testdata/conf.d/*.yaml")
+		So(err, ShouldBeNil)
+		So(files, ShouldResemble, []string{"testdata/conf.d/20-cpu.yaml"})
+
+		_, err = expandSetfile("testdata/missing.json")
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("load directory with includes", t, func() {
+		configs, err := loadSetfile("testdata/conf.d")
+		So(err, ShouldBeNil)
+
+		sources := []string{}
+		for _, c := range *configs {
+			sources = append(sources, c.source)
+		}
+		So(sources, ShouldResemble, []string{
+			"testdata/conf.d/10-load.json:4 entry 0",
+			"testdata/conf.d/shared/cgroup.yaml:4 entry 0",
+			"testdata/conf.d/20-cpu.yaml:3 entry 0",
+		})
+		So(validateSetfile("testdata/conf.d", nil), ShouldBeEmpty)
+	})
+
+	Convey("shared includes are only loaded once", t, func() {
+		dir, err := ioutil.TempDir("", "setfile")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		entry := func(name string) string {
+			return "{\"file\": \"/proc/loadavg\", \"metrics\": {\"/test/" + name + "\": \"{1m}\"}, \"parser\": {\"format\": \"table\", \"columns\": [\"1m\"]}}"
+		}
+		main := filepath.Join(dir, "main.json")
+		ioutil.WriteFile(main, []byte("{\"include\": [\"a.json\", \"b.json\"]}"), 0644)
+		ioutil.WriteFile(filepath.Join(dir, "a.json"), []byte("{\"include\": [\"templates.yaml\"], \"files\": ["+entry("a")+"]}"), 0644)
+		ioutil.WriteFile(filepath.Join(dir, "b.json"), []byte("{\"include\": [\"templates.yaml\"], \"files\": ["+entry("b")+"]}"), 0644)
+		ioutil.WriteFile(filepath.Join(dir, "templates.yaml"), []byte("files: ["+entry("shared")+"]"), 0644)
+
+		parts, err := loadSetfileParts(main)
+		So(err, ShouldBeNil)
+		files := []string{}
+		for _, p := range parts {
+			files = append(files, filepath.Base(p.file))
+		}
+		So(files, ShouldResemble, []string{"main.json", "a.json", "templates.yaml", "b.json"})
+	})
+
+	Convey("include errors", t, func() {
+		dir, err := ioutil.TempDir("", "setfile")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		a := filepath.Join(dir, "a.json")
+		b := filepath.Join(dir, "b.yaml")
+		entry := "{\"file\": \"/proc/loadavg\", \"metrics\": {\"/test/load\": \"{1m}\"}, \"parser\": {\"format\": \"table\", \"columns\": [\"1m\"]}}"
+		ioutil.WriteFile(a, []byte("{\"include\": [\"b.yaml\"], \"files\": ["+entry+"]}"), 0644)
+		ioutil.WriteFile(b, []byte("files: ["+entry+"]"), 0644)
+
+		configs, err := loadSetfile(a)
+		So(err, ShouldBeNil)
+		So(checkNamespaceCollisions(*configs).Error(), ShouldEqual, "namespace collision: "+b+":1 entry 0 metric '/test/load' has the same namespace as "+a+":1 entry 0 metric '/test/load'")
+
+		ioutil.WriteFile(b, []byte("include: [a.json]"), 0644)
+		_, err = loadSetfile(a)
+		So(err.Error(), ShouldEqual, b+": include cycle: "+a+" -> "+b+" -> "+a)
+
+		ioutil.WriteFile(b, []byte("include: [missing.json]"), 0644)
+		_, err = loadSetfile(a)
+		So(err.Error(), ShouldEqual, b+": include: stat "+dir+"/missing.json: no such file or directory")
+	})
+
+	Convey("parsers and templates are shared by all parts", t, func() {
+		dir, err := ioutil.TempDir("", "setfile")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		main := filepath.Join(dir, "main.json")
+		shared := filepath.Join(dir, "shared.yaml")
+		ioutil.WriteFile(main, []byte("{\"include\": [\"shared.yaml\"], \"files\": [{\"template\": \"a\", \"metrics\": {\"/test/load\": \"{1m}\"}}, {\"file\": \"/proc/uptime\", \"parser\": \"p\", \"metrics\": {\"/test/uptime\": \"{up}\"}}]}"), 0644)
+		ioutil.WriteFile(shared, []byte("parsers:\n  p:\n    format: table\n    columns: [up]\ntemplates:\n  a:\n    file: /proc/loadavg\n    parser: {format: table, columns: [1m]}\n"), 0644)
+
+		configs, err := loadSetfile(main)
+		So(err, ShouldBeNil)
+		So(len(*configs), ShouldEqual, 2)
+		So((*configs)[0].File, ShouldEqual, "/proc/loadavg")
+		So((*configs)[0].Parser.Columns, ShouldResemble, []string{"1m"})
+		So((*configs)[1].Parser.Columns, ShouldResemble, []string{"up"})
+		So(validateSetfile(main, nil), ShouldBeEmpty)
+
+		ioutil.WriteFile(main, []byte("{\"include\": [\"shared.yaml\"],\n \"templates\": {\n  \"a\": {\"file\": \"/proc/uptime\"}}}"), 0644)
+		_, err = loadSetfile(main)
+		So(err.Error(), ShouldEqual, shared+":6: template 'a' is already defined at "+main+":3")
+	})
+}
@@ -0,0 +1,199 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Template that can be extended by the file configs of a setfile. It has the
+// same fields as a file config, which will be used if not set on the entry,
+// along with prefixes that are prepended to the file pattern and metric
+// namespaces of the entry. For example:
+//
+//	"templates": {
+//	  "docker": {
+//	    "file_prefix": "/sys/fs/cgroup/",
+//	    "namespace_prefix": "/netflix/linux/docker/{container:path:-2}",
+//	    "tags": {"atlas.dstype": "gauge"},
+//	    "parser": "single-value"
+//	  }
+//	}
+//
+// Tags are merged with the tags of the entry, all other fields are replaced.
+// This struct only has the fields that are specific to templates.
+type templateConfig struct {
+	FilePrefix      string `json:"file_prefix"`
+	NamespacePrefix string `json:"namespace_prefix"`
+}
+
+// Names of the fields that can be used in a template.
+func templateFields() map[string]bool {
+	fields := jsonFields(fileConfig{})
+	delete(fields, "template")
+	for k := range jsonFields(templateConfig{}) {
+		fields[k] = true
+	}
+	return fields
+}
+
+// Create the file config for an entry of the setfile. If the entry extends a
+// template or refers to a named parser, then they will be looked up in the
+// document.
+func (d *setfileDoc) resolve(raw json.RawMessage) (fileConfig, error) {
+	c := fileConfig{}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return c, err
+	}
+
+	tmpl := templateConfig{}
+	if value, ok := fields["template"]; ok {
+		name := ""
+		if err := json.Unmarshal(value, &name); err != nil {
+			return c, errors.New("template must be the name of a template")
+		}
+		t, err := d.template(name)
+		if err != nil {
+			return c, err
+		}
+		tmpl = t
+		if fields, err = d.extend(name, fields); err != nil {
+			return c, err
+		}
+	}
+
+	if parser, ok := fields["parser"]; ok {
+		p, err := d.parser(parser)
+		if err != nil {
+			return c, err
+		}
+		fields["parser"] = p
+	}
+
+	if value, ok := fields["inputs"]; ok {
+		inputs, err := d.resolveInputs(value)
+		if err != nil {
+			return c, err
+		}
+		fields["inputs"] = inputs
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, err
+	}
+
+	if len(c.Inputs) > 0 {
+		for name, input := range c.Inputs {
+			input.File = tmpl.FilePrefix + input.File
+			c.Inputs[name] = input
+		}
+	} else {
+		c.File = tmpl.FilePrefix + c.File
+	}
+	if tmpl.NamespacePrefix != "" {
+		metrics := map[string]metricConfig{}
+		for k, v := range c.Metrics {
+			metrics[tmpl.NamespacePrefix+k] = v
+		}
+		c.Metrics = metrics
+	}
+	return c, nil
+}
+
+// Lookup a template by name.
+func (d *setfileDoc) template(name string) (templateConfig, error) {
+	tmpl := templateConfig{}
+	raw, ok := d.Templates[name]
+	if !ok {
+		return tmpl, errors.New(fmt.Sprintf("unknown template '%v'", name))
+	}
+	if err := json.Unmarshal(raw, &tmpl); err != nil {
+		return tmpl, errors.New(fmt.Sprintf("template '%v': %v", name, err))
+	}
+	return tmpl, nil
+}
+
+// Merge the fields of an entry with the fields of the template it extends.
+func (d *setfileDoc) extend(name string, fields map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	merged := map[string]json.RawMessage{}
+	if err := json.Unmarshal(d.Templates[name], &merged); err != nil {
+		return nil, err
+	}
+	for f := range jsonFields(templateConfig{}) {
+		delete(merged, f)
+	}
+
+	for k, v := range fields {
+		if k == "template" {
+			continue
+		}
+		if k == "tags" && merged[k] != nil {
+			tags := map[string]string{}
+			if err := json.Unmarshal(merged[k], &tags); err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(v, &tags); err != nil {
+				return nil, err
+			}
+			data, err := json.Marshal(tags)
+			if err != nil {
+				return nil, err
+			}
+			v = data
+		}
+		merged[k] = v
+	}
+	return merged, nil
+}
+
+// Resolve the named parsers used by the inputs of an entry.
+func (d *setfileDoc) resolveInputs(value json.RawMessage) (json.RawMessage, error) {
+	inputs := map[string]map[string]json.RawMessage{}
+	if err := json.Unmarshal(value, &inputs); err != nil {
+		return nil, err
+	}
+	for name, input := range inputs {
+		if parser, ok := input["parser"]; ok {
+			p, err := d.parser(parser)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("input '%v': %v", name, err))
+			}
+			input["parser"] = p
+		}
+	}
+	return json.Marshal(inputs)
+}
+
+// Get the parser config for the parser field of an entry. If it is a string,
+// then it is the name of a parser in the parsers section.
+func (d *setfileDoc) parser(value json.RawMessage) (json.RawMessage, error) {
+	name := ""
+	if err := json.Unmarshal(value, &name); err != nil {
+		return value, nil
+	}
+	raw, ok := d.Parsers[name]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unknown parser '%v'", name))
+	}
+	return raw, nil
+}
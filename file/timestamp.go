@@ -0,0 +1,134 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Sources for the timestamp of the metrics created from a file.
+const (
+	// Start time of the collection, the same for all metrics in a batch.
+	timestampCollection = "collection"
+
+	// Modification time of the file.
+	timestampMtime = "mtime"
+
+	// Value of a record variable that is parsed using the layout.
+	timestampField = "field"
+)
+
+var timestampSources = []string{timestampCollection, timestampMtime, timestampField}
+
+// Layouts for a timestamp field in addition to the Go time layouts.
+const (
+	layoutRFC3339      = "rfc3339"
+	layoutEpochSeconds = "epoch"
+	layoutEpochMillis  = "epoch_ms"
+)
+
+// Config for how to determine the timestamp of a metric. In the setfile it
+// can either be the name of the source or an object, for example:
+//
+//	"timestamp": "mtime"
+//	"timestamp": {"source": "field", "field": "time", "layout": "epoch_ms"}
+//
+// The layout can be rfc3339, epoch, epoch_ms or a Go time layout such as
+// '2006-01-02 15:04:05'. The default is rfc3339.
+type timestampConfig struct {
+	Source string `json:"source"`
+	Field  string `json:"field"`
+	Layout string `json:"layout"`
+}
+
+func (t *timestampConfig) UnmarshalJSON(data []byte) error {
+	source := ""
+	if json.Unmarshal(data, &source) == nil {
+		*t = timestampConfig{Source: source}
+		return nil
+	}
+	type plain timestampConfig
+	return json.Unmarshal(data, (*plain)(t))
+}
+
+func (t timestampConfig) source() string {
+	if t.Source == "" {
+		return timestampCollection
+	}
+	return t.Source
+}
+
+func (t timestampConfig) validate() error {
+	if !contains(timestampSources, t.source()) {
+		msg := fmt.Sprintf("unknown timestamp source: '%v', expected one of %v", t.Source, timestampSources)
+		return errors.New(msg)
+	}
+	if t.source() == timestampField && t.Field == "" {
+		return errors.New("field must be specified for timestamp source 'field'")
+	}
+	if t.source() != timestampField && (t.Field != "" || t.Layout != "") {
+		return errors.New("field and layout can only be used with timestamp source 'field'")
+	}
+	return nil
+}
+
+// Get the timestamp for a record. The start is the start time of the
+// collection and the mtime is the modification time of the file.
+func (t timestampConfig) timestamp(start time.Time, mtime time.Time, vars map[string]interface{}) (time.Time, error) {
+	switch t.source() {
+	case timestampMtime:
+		return mtime, nil
+	case timestampField:
+		value, ok := vars[t.Field]
+		if !ok {
+			return time.Time{}, errors.New(fmt.Sprintf("no value for timestamp field '%v'", t.Field))
+		}
+		return parseTimestamp(value, t.Layout)
+	default:
+		return start, nil
+	}
+}
+
+// Parse the value of a timestamp field. Numeric values will have already
+// been converted to float64 by the parser.
+func parseTimestamp(value interface{}, layout string) (time.Time, error) {
+	switch layout {
+	case layoutEpochSeconds, layoutEpochMillis:
+		v, err := toNumber(value)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if layout == layoutEpochMillis {
+			v = v / 1000.0
+		}
+		secs, frac := math.Modf(v)
+		return time.Unix(int64(secs), int64(frac*1e9)), nil
+	}
+
+	str := fmt.Sprintf("%v", value)
+	if v, ok := value.(float64); ok {
+		str = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	if layout == "" || layout == layoutRFC3339 {
+		layout = time.RFC3339Nano
+	}
+	return time.Parse(layout, str)
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTimestamp(t *testing.T) {
+
+	Convey("parseTimestamp", t, func() {
+		expected := time.Date(2016, 5, 4, 3, 2, 1, 0, time.UTC)
+
+		ts, err := parseTimestamp("2016-05-04T03:02:01Z", "")
+		So(err, ShouldBeNil)
+		So(ts.Equal(expected), ShouldBeTrue)
+
+		ts, err = parseTimestamp(1462330921.0, layoutEpochSeconds)
+		So(err, ShouldBeNil)
+		So(ts.Equal(expected), ShouldBeTrue)
+
+		ts, err = parseTimestamp(1462330921500.0, layoutEpochMillis)
+		So(err, ShouldBeNil)
+		So(ts.Equal(expected.Add(500*time.Millisecond)), ShouldBeTrue)
+
+		ts, err = parseTimestamp(20160504.0, "20060102")
+		So(err, ShouldBeNil)
+		So(ts.Equal(time.Date(2016, 5, 4, 0, 0, 0, 0, time.UTC)), ShouldBeTrue)
+
+		_, err = parseTimestamp("yesterday", layoutEpochSeconds)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("timestampConfig", t, func() {
+		c := timestampConfig{}
+		So(json.Unmarshal([]byte("\"mtime\""), &c), ShouldBeNil)
+		So(c, ShouldResemble, timestampConfig{Source: timestampMtime})
+		So(c.validate(), ShouldBeNil)
+
+		So(timestampConfig{}.source(), ShouldEqual, timestampCollection)
+		So(timestampConfig{Source: "now"}.validate().Error(), ShouldEqual, "unknown timestamp source: 'now', expected one of [collection mtime field]")
+		So(timestampConfig{Source: timestampField}.validate().Error(), ShouldEqual, "field must be specified for timestamp source 'field'")
+		So(timestampConfig{Field: "time"}.validate().Error(), ShouldEqual, "field and layout can only be used with timestamp source 'field'")
+	})
+
+	Convey("collect with timestamps", t, func() {
+		now := time.Now()
+		fs := newMemFileSystem()
+		fs.add("/var/run/fresh", []byte("1 1462330921\n2 1462330922\n"), now.Add(-10*time.Second))
+		fs.add("/var/run/stale", []byte("3 1462330923\n"), now.Add(-time.Hour))
+
+		c := fileConfig{
+			File: "/var/run/*",
+			Metrics: map[string]metricConfig{
+				"/test/{file:path:-1}/{id}/value": newMetricConfig("{id}"),
+			},
+			Parser: newTableConfig([]string{"id", "time"}, 0),
+		}
+
+		ctx := newCollectContext(log.New(), fs)
+		ms, err := c.collectMetrics(ctx, nil)
+		So(err, ShouldBeNil)
+		So(len(ms), ShouldEqual, 3)
+		for _, m := range ms {
+			So(m.Timestamp(), ShouldResemble, ctx.start)
+		}
+
+		c.Timestamp = timestampConfig{Source: timestampMtime}
+		c.MaxAge = 60
+		ms, err = c.collectMetrics(newCollectContext(log.New(), fs), nil)
+		So(err, ShouldBeNil)
+		So(len(ms), ShouldEqual, 2)
+		So(ms[0].Timestamp().Equal(now.Add(-10*time.Second)), ShouldBeTrue)
+
+		c.Timestamp = timestampConfig{Source: timestampField, Field: "time", Layout: layoutEpochSeconds}
+		ms, err = c.collectMetrics(newCollectContext(log.New(), fs), nil)
+		So(err, ShouldBeNil)
+		So(ms[1].Timestamp().Unix(), ShouldEqual, int64(1462330922))
+	})
+}
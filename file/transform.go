@@ -0,0 +1,334 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Step for converting the value of a field. The transforms of the parser
+// config map a field name to a single step or a list of steps that are
+// applied in order, for example:
+//
+//	"transforms": {
+//	  "local_port": "hex",
+//	  "status": {"type": "enum", "values": {"active": 1, "inactive": 0}},
+//	  "usage": ["thousands", "percent"]
+//	}
+//
+// A step can be written as just the type if it does not need any of the
+// other settings. The input for each step is the raw string for the first
+// step or the string form of the previous result. If a step fails, then the
+// value is kept as the raw string, so expressions that need a number will
+// fail for it.
+type transformStep struct {
+	Type string `json:"type"`
+
+	// Layout for the 'date' type, see timestampConfig.
+	Layout string `json:"layout"`
+
+	// Values for the 'enum' type.
+	Values map[string]float64 `json:"values"`
+
+	// Value used by the 'enum' type if the value is not in the map.
+	Default *float64 `json:"default"`
+
+	// Separator for the 'thousands' type, defaults to ','.
+	Separator string `json:"separator"`
+}
+
+func (t *transformStep) UnmarshalJSON(data []byte) error {
+	name := ""
+	if json.Unmarshal(data, &name) == nil {
+		*t = transformStep{Type: name}
+		return nil
+	}
+	type plain transformStep
+	return json.Unmarshal(data, (*plain)(t))
+}
+
+// List of transform steps for a field.
+type transformPipeline []transformStep
+
+func (p *transformPipeline) UnmarshalJSON(data []byte) error {
+	steps := []transformStep{}
+	if json.Unmarshal(data, &steps) == nil {
+		*p = steps
+		return nil
+	}
+	step := transformStep{}
+	if err := json.Unmarshal(data, &step); err != nil {
+		return err
+	}
+	*p = transformPipeline{step}
+	return nil
+}
+
+func newTransformPipeline(names ...string) transformPipeline {
+	p := transformPipeline{}
+	for _, name := range names {
+		p = append(p, transformStep{Type: name})
+	}
+	return p
+}
+
+var transforms = map[string]func(transformStep, string) (interface{}, error){
+	"hex":       baseTransform(16, "0x"),
+	"octal":     baseTransform(8, "0o"),
+	"binary":    baseTransform(2, "0b"),
+	"hex_ip":    hexToIP,
+	"tcp_state": tcpStateName,
+	"duration":  durationSeconds,
+	"hms":       hmsSeconds,
+	"date":      dateSeconds,
+	"bool":      boolNumber,
+	"enum":      enumValue,
+	"thousands": withoutSeparators,
+	"percent":   withoutPercent,
+}
+
+func transformNames() []string {
+	names := []string{}
+	for k := range transforms {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (t transformStep) validate() error {
+	if _, ok := transforms[t.Type]; !ok {
+		return errors.New(fmt.Sprintf("unknown transform: '%v', expected one of %v", t.Type, transformNames()))
+	}
+	if t.Type == "enum" && len(t.Values) == 0 {
+		return errors.New("values must be specified for transform 'enum'")
+	}
+	if t.Type != "enum" && (len(t.Values) > 0 || t.Default != nil) {
+		return errors.New("values and default can only be used with transform 'enum'")
+	}
+	if t.Type != "date" && t.Layout != "" {
+		return errors.New("layout can only be used with transform 'date'")
+	}
+	if t.Type != "thousands" && t.Separator != "" {
+		return errors.New("separator can only be used with transform 'thousands'")
+	}
+	return nil
+}
+
+func validateTransforms(ts map[string]transformPipeline) error {
+	for _, k := range sortedKeys(ts) {
+		if len(ts[k]) == 0 {
+			return errors.New(fmt.Sprintf("transform for '%v' must have at least one step", k))
+		}
+		for _, step := range ts[k] {
+			if err := step.validate(); err != nil {
+				return errors.New(fmt.Sprintf("transform for '%v': %v", k, err))
+			}
+		}
+	}
+	return nil
+}
+
+// Apply the steps to the raw value of a field.
+func (p transformPipeline) apply(data string) interface{} {
+	tmp := strings.Trim(data, ": \t\r\n")
+	var value interface{} = tmp
+	for _, step := range p {
+		fn, ok := transforms[step.Type]
+		if !ok {
+			return parseValue(data)
+		}
+		str := fmt.Sprintf("%v", value)
+		if v, ok := value.(float64); ok {
+			str = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		v, err := fn(step, str)
+		if err != nil {
+			return tmp
+		}
+		value = v
+	}
+	return value
+}
+
+func baseTransform(base int, prefix string) func(transformStep, string) (interface{}, error) {
+	return func(t transformStep, data string) (interface{}, error) {
+		data = strings.TrimPrefix(strings.ToLower(data), prefix)
+		v, err := strconv.ParseUint(data, base, 64)
+		if err != nil {
+			return nil, err
+		}
+		return float64(v), nil
+	}
+}
+
+// Convert an address in the format used by /proc/net/tcp and similar files.
+// The address is written as 32-bit words in host byte order, which is
+// assumed to be little endian.
+func hexToIP(t transformStep, data string) (interface{}, error) {
+	b, err := hex.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != net.IPv4len && len(b) != net.IPv6len {
+		return nil, errors.New(fmt.Sprintf("invalid address length: %d", len(b)))
+	}
+	ip := make(net.IP, len(b))
+	for i := 0; i < len(b); i += 4 {
+		ip[i], ip[i+1], ip[i+2], ip[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+	}
+	return ip.String(), nil
+}
+
+// Names for the TCP states used in the st column of /proc/net/tcp, see
+// include/net/tcp_states.h in the kernel.
+var tcpStates = map[uint64]string{
+	0x01: "ESTABLISHED",
+	0x02: "SYN_SENT",
+	0x03: "SYN_RECV",
+	0x04: "FIN_WAIT1",
+	0x05: "FIN_WAIT2",
+	0x06: "TIME_WAIT",
+	0x07: "CLOSE",
+	0x08: "CLOSE_WAIT",
+	0x09: "LAST_ACK",
+	0x0A: "LISTEN",
+	0x0B: "CLOSING",
+	0x0C: "NEW_SYN_RECV",
+}
+
+func tcpStateName(t transformStep, data string) (interface{}, error) {
+	v, err := strconv.ParseUint(data, 16, 8)
+	if err != nil {
+		return nil, err
+	}
+	name, ok := tcpStates[v]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unknown tcp state: %v", data))
+	}
+	return name, nil
+}
+
+// Go duration such as '1h2m3s' converted to seconds.
+func durationSeconds(t transformStep, data string) (interface{}, error) {
+	d, err := time.ParseDuration(data)
+	if err != nil {
+		return nil, err
+	}
+	return d.Seconds(), nil
+}
+
+// Elapsed time in the form [[DD-]HH:]MM:SS, as used by ps, converted to
+// seconds. The seconds can have a fraction.
+func hmsSeconds(t transformStep, data string) (interface{}, error) {
+	days := 0.0
+	if pos := strings.Index(data, "-"); pos >= 0 {
+		v, err := strconv.ParseUint(data[:pos], 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		days = float64(v)
+		data = data[pos+1:]
+	}
+
+	parts := strings.Split(data, ":")
+	if len(parts) > 3 {
+		return nil, errors.New(fmt.Sprintf("invalid time, expected [[DD-]HH:]MM:SS: '%v'", data))
+	}
+	secs := 0.0
+	for _, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 {
+			return nil, errors.New(fmt.Sprintf("invalid time, expected [[DD-]HH:]MM:SS: '%v'", data))
+		}
+		secs = secs*60 + v
+	}
+	return days*86400 + secs, nil
+}
+
+// Date converted to seconds since the epoch. For the epoch layouts the data
+// is converted to a number first since parseTimestamp expects the parser to
+// have done so.
+func dateSeconds(t transformStep, data string) (interface{}, error) {
+	var value interface{} = data
+	if t.Layout == layoutEpochSeconds || t.Layout == layoutEpochMillis {
+		v, err := strconv.ParseFloat(strings.TrimSpace(data), 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid %s time: '%v'", t.Layout, data))
+		}
+		value = v
+	}
+	ts, err := parseTimestamp(value, t.Layout)
+	if err != nil {
+		return nil, err
+	}
+	return float64(ts.UnixNano()) / 1e9, nil
+}
+
+var boolValues = map[string]float64{
+	"1":        1,
+	"true":     1,
+	"yes":      1,
+	"on":       1,
+	"enabled":  1,
+	"0":        0,
+	"false":    0,
+	"no":       0,
+	"off":      0,
+	"disabled": 0,
+}
+
+func boolNumber(t transformStep, data string) (interface{}, error) {
+	v, ok := boolValues[strings.ToLower(data)]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("invalid boolean: '%v'", data))
+	}
+	return v, nil
+}
+
+func enumValue(t transformStep, data string) (interface{}, error) {
+	if v, ok := t.Values[data]; ok {
+		return v, nil
+	}
+	if t.Default != nil {
+		return *t.Default, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown value: '%v'", data))
+}
+
+// Number with thousands separators such as '1,234,567'. The separators are
+// removed and the rest of the value is parsed as usual, so it can be combined
+// with other steps such as percent.
+func withoutSeparators(t transformStep, data string) (interface{}, error) {
+	sep := t.Separator
+	if sep == "" {
+		sep = ","
+	}
+	return parseValue(strings.Replace(data, sep, "", -1)), nil
+}
+
+// Percentage such as '45.5%', the value is kept in the range 0 to 100.
+func withoutPercent(t transformStep, data string) (interface{}, error) {
+	return parseValue(strings.TrimSuffix(data, "%")), nil
+}
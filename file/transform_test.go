@@ -0,0 +1,142 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTransform(t *testing.T) {
+
+	apply := func(name string, data string) interface{} {
+		return newTransformPipeline(name).apply(data)
+	}
+
+	Convey("hex, octal and binary", t, func() {
+		So(apply("hex", "0050"), ShouldEqual, 80.0)
+		So(apply("hex", "1E61"), ShouldEqual, 7777.0)
+		So(apply("hex", "0x1f"), ShouldEqual, 31.0)
+		So(apply("hex", "xyz"), ShouldEqual, "xyz")
+		So(apply("octal", "0755"), ShouldEqual, 493.0)
+		So(apply("octal", "0o17"), ShouldEqual, 15.0)
+		So(apply("binary", "0b101"), ShouldEqual, 5.0)
+		So(apply("binary", "102"), ShouldEqual, "102")
+	})
+
+	Convey("hex_ip", t, func() {
+		So(apply("hex_ip", "0100007F"), ShouldEqual, "127.0.0.1")
+		So(apply("hex_ip", "0F02000A"), ShouldEqual, "10.0.2.15")
+		So(apply("hex_ip", "00000000000000000000000001000000"), ShouldEqual, "::1")
+		So(apply("hex_ip", "B80D01200000000067452301EFCDAB89"), ShouldEqual, "2001:db8::123:4567:89ab:cdef")
+		So(apply("hex_ip", "0100"), ShouldEqual, "0100")
+	})
+
+	Convey("tcp_state", t, func() {
+		So(apply("tcp_state", "01"), ShouldEqual, "ESTABLISHED")
+		So(apply("tcp_state", "0A"), ShouldEqual, "LISTEN")
+		So(apply("tcp_state", "FF"), ShouldEqual, "FF")
+	})
+
+	Convey("durations", t, func() {
+		So(apply("duration", "1h2m"), ShouldEqual, 3720.0)
+		So(apply("duration", "250ms"), ShouldEqual, 0.25)
+		So(apply("hms", "01:02:03"), ShouldEqual, 3723.0)
+		So(apply("hms", "02:03.5"), ShouldEqual, 123.5)
+		So(apply("hms", "2-01:00:00"), ShouldEqual, 176400.0)
+		So(apply("hms", "42"), ShouldEqual, 42.0)
+		So(apply("hms", "1:-2"), ShouldEqual, "1:-2")
+	})
+
+	Convey("date", t, func() {
+		So(apply("date", "2016-08-02T00:00:00Z"), ShouldEqual, 1470096000.0)
+		step := transformStep{Type: "date", Layout: "2006-01-02 15:04"}
+		So(transformPipeline{step}.apply("2016-08-02 00:01"), ShouldEqual, 1470096060.0)
+		So(apply("date", "yesterday"), ShouldEqual, "yesterday")
+
+		step = transformStep{Type: "date", Layout: "epoch"}
+		So(transformPipeline{step}.apply("1470096000"), ShouldEqual, 1470096000.0)
+		So(transformPipeline{step}.apply("1470096000.5"), ShouldEqual, 1470096000.5)
+		So(transformPipeline{step}.apply("now"), ShouldEqual, "now")
+
+		step = transformStep{Type: "date", Layout: "epoch_ms"}
+		So(transformPipeline{step}.apply("1470096000500"), ShouldEqual, 1470096000.5)
+		So(transformPipeline{step}.apply("1470096000250ms"), ShouldEqual, "1470096000250ms")
+	})
+
+	Convey("bool and enum", t, func() {
+		So(apply("bool", "on"), ShouldEqual, 1.0)
+		So(apply("bool", "No"), ShouldEqual, 0.0)
+		So(apply("bool", "maybe"), ShouldEqual, "maybe")
+
+		step := transformStep{Type: "enum", Values: map[string]float64{"active": 1, "failed": -1}}
+		So(transformPipeline{step}.apply("active"), ShouldEqual, 1.0)
+		So(transformPipeline{step}.apply("inactive"), ShouldEqual, "inactive")
+		zero := 0.0
+		step.Default = &zero
+		So(transformPipeline{step}.apply("inactive"), ShouldEqual, 0.0)
+	})
+
+	Convey("thousands and percent", t, func() {
+		So(apply("thousands", "1,234,567"), ShouldEqual, 1234567.0)
+		So(transformPipeline{{Type: "thousands", Separator: "."}}.apply("1.234"), ShouldEqual, 1234.0)
+		So(apply("percent", "45.5%"), ShouldEqual, 45.5)
+		So(newTransformPipeline("thousands", "percent").apply("1,050%"), ShouldEqual, 1050.0)
+	})
+
+	Convey("unmarshal", t, func() {
+		ts := map[string]transformPipeline{}
+		data := `{"a": "hex", "b": {"type": "enum", "values": {"up": 1}}, "c": ["thousands", {"type": "percent"}]}`
+		So(json.Unmarshal([]byte(data), &ts), ShouldBeNil)
+		So(ts["a"], ShouldResemble, newTransformPipeline("hex"))
+		So(ts["b"], ShouldResemble, transformPipeline{{Type: "enum", Values: map[string]float64{"up": 1}}})
+		So(ts["c"], ShouldResemble, newTransformPipeline("thousands", "percent"))
+	})
+
+	Convey("validateTransforms", t, func() {
+		So(validateTransforms(map[string]transformPipeline{"port": newTransformPipeline("hex")}), ShouldBeNil)
+		So(validateTransforms(map[string]transformPipeline{"port": newTransformPipeline("base64")}).Error(), ShouldEqual,
+			"transform for 'port': unknown transform: 'base64', expected one of [binary bool date duration enum hex hex_ip hms octal percent tcp_state thousands]")
+		So(validateTransforms(map[string]transformPipeline{"state": newTransformPipeline("enum")}).Error(), ShouldEqual,
+			"transform for 'state': values must be specified for transform 'enum'")
+		So(validateTransforms(map[string]transformPipeline{"t": {{Type: "hex", Layout: "epoch"}}}).Error(), ShouldEqual,
+			"transform for 't': layout can only be used with transform 'date'")
+		So(validateTransforms(map[string]transformPipeline{"t": {}}).Error(), ShouldEqual,
+			"transform for 't' must have at least one step")
+	})
+
+	Convey("parser with transforms", t, func() {
+		cfg := newTableConfig([]string{"addr", "port", "st"}, 0)
+		cfg.Transforms = map[string]transformPipeline{
+			"addr": newTransformPipeline("hex_ip"),
+			"port": newTransformPipeline("hex"),
+			"st":   newTransformPipeline("tcp_state"),
+		}
+		rows, err := newParser(cfg).parseString("0100007F 1E61 0A\n")
+		So(err, ShouldBeNil)
+		So(rows, ShouldResemble, []map[string]interface{}{
+			{"addr": "127.0.0.1", "port": 7777.0, "st": "LISTEN"},
+		})
+
+		cfg = defaultKeyValueConfig()
+		cfg.Transforms = map[string]transformPipeline{"Uptime": newTransformPipeline("hms")}
+		rows, err = newParser(cfg).parseString("Uptime 01:00:00\nState up\n")
+		So(err, ShouldBeNil)
+		So(rows[0]["Uptime"], ShouldEqual, 3600.0)
+	})
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Units that can be used for metrics. Using a fixed set keeps the values
+// consistent so that, for example, 'bytes' is not also written as 'B' or
+// 'byte'. A rate can be specified as '<unit>/<time unit>', e.g.
+// 'bytes/second'.
+var units = []string{
+	"bits",
+	"bytes",
+	"kilobytes",
+	"megabytes",
+	"pages",
+
+	"nanoseconds",
+	"microseconds",
+	"milliseconds",
+	"seconds",
+	"minutes",
+	"hours",
+	"jiffies",
+
+	"count",
+	"connections",
+	"errors",
+	"faults",
+	"files",
+	"operations",
+	"packets",
+	"processes",
+	"requests",
+	"threads",
+
+	"cores",
+	"hertz",
+	"celsius",
+	"percent",
+	"ratio",
+}
+
+var timeUnits = []string{"nanosecond", "microsecond", "millisecond", "second", "minute", "hour"}
+
+func contains(vs []string, v string) bool {
+	for _, s := range vs {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Check that the unit is in the vocabulary. An empty unit means that the unit
+// is not known.
+func validateUnit(unit string) error {
+	if unit == "" || contains(units, unit) {
+		return nil
+	}
+	parts := strings.Split(unit, "/")
+	if len(parts) == 2 && contains(units, parts[0]) && contains(timeUnits, parts[1]) {
+		return nil
+	}
+	msg := fmt.Sprintf("unknown unit: '%v', expected one of %v or a rate such as 'bytes/second'", unit, units)
+	return errors.New(msg)
+}
@@ -0,0 +1,591 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Problem found when validating a setfile. The entry is the index of the
+// file config in the setfile or -1 if the problem is not specific to an
+// entry. The line is 0 if it is not known.
+type validationError struct {
+	File  string
+	Line  int
+	Entry int
+	Field string
+	Msg   string
+}
+
+func (e validationError) Error() string {
+	loc := e.File
+	if e.Line > 0 {
+		loc += fmt.Sprintf(":%d", e.Line)
+	}
+	if e.Entry >= 0 {
+		loc += fmt.Sprintf(": entry %d", e.Entry)
+	}
+	if e.Field != "" {
+		loc += ": " + e.Field
+	}
+	return loc + ": " + e.Msg
+}
+
+// Names of the JSON fields for a struct based on the field tags.
+func jsonFields(v interface{}) map[string]bool {
+	fields := map[string]bool{}
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// Line number for a byte offset in the data.
+func lineNumber(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// Offset of the first byte that is not whitespace or a separator at or
+// after the given offset.
+func skipSpace(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) && strings.IndexByte(" \t\r\n,", data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// Convert an error from decoding an entry to a validation error.
+func jsonError(file string, line int, entry int, err error) validationError {
+	e := validationError{file, line, entry, "", err.Error()}
+	if te, ok := err.(*json.UnmarshalTypeError); ok {
+		e.Field = te.Field
+		e.Msg = fmt.Sprintf("expected %v, found %v", te.Type, te.Value)
+	}
+	return e
+}
+
+// An entry of the setfile along with the position it was found.
+type setfileEntry struct {
+	file   string
+	index  int
+	line   int
+	config fileConfig
+}
+
+// Check the structure of a setfile and decode the entries. The data is the
+// setfile converted to JSON and the lines are the line numbers for each entry
+// in the original file, if known. Unknown keys are reported as errors so that
+// typos will not be silently ignored.
+func validateJson(file string, data []byte, lines []int) ([]setfileEntry, []error) {
+	return validateJsonWith(file, data, lines, nil)
+}
+
+// Same as validateJson, but if shared is set, then the entries are resolved
+// with the named parsers and templates from it, see sharedDefinitions.
+func validateJsonWith(file string, data []byte, lines []int, shared *setfileDoc) ([]setfileEntry, []error) {
+	line := func(i int) int {
+		if i < len(lines) {
+			return lines[i]
+		}
+		return 0
+	}
+
+	doc := setfileDoc{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, []error{validationError{file, 0, -1, "", err.Error()}}
+	}
+
+	errs := []error{}
+	if !isJsonList(data) {
+		fields := map[string]json.RawMessage{}
+		json.Unmarshal(data, &fields)
+		errs = append(errs, unknownFields(file, 0, -1, "", fields, jsonFields(setfileDoc{}))...)
+	}
+
+	for _, name := range sortedKeys(doc.Parsers) {
+		prefix := fmt.Sprintf("parsers[%v].", name)
+		errs = append(errs, parserFields(file, 0, -1, prefix, doc.Parsers[name])...)
+	}
+	for _, name := range sortedKeys(doc.Templates) {
+		prefix := fmt.Sprintf("templates[%v].", name)
+		fields := map[string]json.RawMessage{}
+		if err := json.Unmarshal(doc.Templates[name], &fields); err != nil {
+			errs = append(errs, validationError{file, 0, -1, prefix[:len(prefix)-1], err.Error()})
+			continue
+		}
+		errs = append(errs, unknownFields(file, 0, -1, prefix, fields, templateFields())...)
+		errs = append(errs, parserFields(file, 0, -1, prefix+"parser.", fields["parser"])...)
+		errs = append(errs, objectFields(file, 0, -1, prefix+"timestamp.", fields["timestamp"], jsonFields(timestampConfig{}))...)
+		errs = append(errs, metricFields(file, 0, -1, prefix, fields["metrics"])...)
+		errs = append(errs, processFields(file, 0, -1, prefix, fields["process"])...)
+		errs = append(errs, listFields(file, 0, -1, prefix+"enrich", fields["enrich"], jsonFields(enrichConfig{}))...)
+		errs = append(errs, listFields(file, 0, -1, prefix+"relabel", fields["relabel"], jsonFields(relabelRule{}))...)
+	}
+
+	entries := []setfileEntry{}
+	for i, raw := range doc.Files {
+		fields := map[string]json.RawMessage{}
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			errs = append(errs, jsonError(file, line(i), i, err))
+			continue
+		}
+		errs = append(errs, unknownFields(file, line(i), i, "", fields, jsonFields(fileConfig{}))...)
+
+		errs = append(errs, parserFields(file, line(i), i, "parser.", fields["parser"])...)
+		errs = append(errs, objectFields(file, line(i), i, "timestamp.", fields["timestamp"], jsonFields(timestampConfig{}))...)
+		errs = append(errs, metricFields(file, line(i), i, "", fields["metrics"])...)
+		errs = append(errs, inputFields(file, line(i), i, fields["inputs"])...)
+		errs = append(errs, processFields(file, line(i), i, "", fields["process"])...)
+		errs = append(errs, listFields(file, line(i), i, "enrich", fields["enrich"], jsonFields(enrichConfig{}))...)
+		errs = append(errs, listFields(file, line(i), i, "relabel", fields["relabel"], jsonFields(relabelRule{}))...)
+
+		scope := doc
+		if shared != nil {
+			scope.Parsers = shared.Parsers
+			scope.Templates = shared.Templates
+		}
+		c, err := scope.resolve(raw)
+		if err != nil {
+			errs = append(errs, jsonError(file, line(i), i, err))
+			continue
+		}
+		entries = append(entries, setfileEntry{file, i, line(i), c})
+	}
+	return entries, errs
+}
+
+func unknownFields(file string, line int, entry int, prefix string, fields map[string]json.RawMessage, known map[string]bool) []error {
+	errs := []error{}
+	for _, k := range sortedKeys(fields) {
+		if !known[k] {
+			errs = append(errs, validationError{file, line, entry, prefix + k, "unknown field"})
+		}
+	}
+	return errs
+}
+
+// Check for unknown fields in a nested object such as the parser config.
+// Values that are not objects, e.g. the name of a parser, are skipped.
+func objectFields(file string, line int, entry int, prefix string, raw json.RawMessage, known map[string]bool) []error {
+	fields := map[string]json.RawMessage{}
+	if json.Unmarshal(raw, &fields) != nil {
+		return nil
+	}
+	return unknownFields(file, line, entry, prefix, fields, known)
+}
+
+// Check for unknown fields in the inputs of an entry.
+func inputFields(file string, line int, entry int, raw json.RawMessage) []error {
+	inputs := map[string]json.RawMessage{}
+	if json.Unmarshal(raw, &inputs) != nil {
+		return nil
+	}
+	errs := []error{}
+	for _, name := range sortedKeys(inputs) {
+		prefix := fmt.Sprintf("inputs[%v].", name)
+		errs = append(errs, objectFields(file, line, entry, prefix, inputs[name], jsonFields(inputConfig{}))...)
+		fields := map[string]json.RawMessage{}
+		if json.Unmarshal(inputs[name], &fields) == nil {
+			errs = append(errs, parserFields(file, line, entry, prefix+"parser.", fields["parser"])...)
+		}
+	}
+	return errs
+}
+
+// Check for unknown fields in a parser config and its aggregate config.
+func parserFields(file string, line int, entry int, prefix string, raw json.RawMessage) []error {
+	errs := objectFields(file, line, entry, prefix, raw, jsonFields(parserConfig{}))
+	fields := map[string]json.RawMessage{}
+	if json.Unmarshal(raw, &fields) == nil {
+		errs = append(errs, objectFields(file, line, entry, prefix+"aggregate.", fields["aggregate"], jsonFields(aggregateConfig{}))...)
+		errs = append(errs, objectFields(file, line, entry, prefix+"normalize.", fields["normalize"], jsonFields(normalizeConfig{}))...)
+		transforms := map[string]json.RawMessage{}
+		json.Unmarshal(fields["transforms"], &transforms)
+		for _, k := range sortedKeys(transforms) {
+			p := fmt.Sprintf("%stransforms[%v]", prefix, k)
+			errs = append(errs, objectFields(file, line, entry, p+".", transforms[k], jsonFields(transformStep{}))...)
+			errs = append(errs, listFields(file, line, entry, p, transforms[k], jsonFields(transformStep{}))...)
+		}
+	}
+	return errs
+}
+
+// Check for unknown fields in the process config and its groups.
+func processFields(file string, line int, entry int, prefix string, raw json.RawMessage) []error {
+	errs := objectFields(file, line, entry, prefix+"process.", raw, jsonFields(processConfig{}))
+	fields := map[string]json.RawMessage{}
+	if json.Unmarshal(raw, &fields) == nil {
+		errs = append(errs, listFields(file, line, entry, prefix+"process.groups", fields["groups"], jsonFields(processGroup{}))...)
+	}
+	return errs
+}
+
+// Check for unknown fields in a list of objects such as the relabel rules.
+func listFields(file string, line int, entry int, prefix string, raw json.RawMessage, known map[string]bool) []error {
+	items := []json.RawMessage{}
+	if json.Unmarshal(raw, &items) != nil {
+		return nil
+	}
+	errs := []error{}
+	for i, item := range items {
+		p := fmt.Sprintf("%s[%d].", prefix, i)
+		errs = append(errs, objectFields(file, line, entry, p, item, known)...)
+	}
+	return errs
+}
+
+// Check for unknown fields in the metrics that are specified as objects.
+func metricFields(file string, line int, entry int, prefix string, raw json.RawMessage) []error {
+	metrics := map[string]json.RawMessage{}
+	if json.Unmarshal(raw, &metrics) != nil {
+		return nil
+	}
+	errs := []error{}
+	for _, k := range sortedKeys(metrics) {
+		fields := map[string]json.RawMessage{}
+		if json.Unmarshal(metrics[k], &fields) == nil {
+			field := fmt.Sprintf("%smetrics[%v].", prefix, k)
+			errs = append(errs, unknownFields(file, line, entry, field, fields, jsonFields(metricConfig{}))...)
+		}
+	}
+	return errs
+}
+
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Find the names of the variables that will be available for a file config.
+// If the parser does not have a fixed set of columns, then the sample files
+// will be parsed to find the names. The second return value is false if the
+// names could not be determined.
+func availableVars(parserCfg parserConfig, pattern *filePattern, samples fileSystem) (map[string]bool, bool, error) {
+	vars := map[string]bool{}
+	for k := range defaultVars() {
+		vars[k] = true
+	}
+	for _, name := range pattern.names {
+		vars[name] = true
+	}
+
+	if names, ok := parserCfg.variables(); ok {
+		for _, name := range names {
+			vars[name] = true
+		}
+		return vars, true, nil
+	}
+
+	if samples == nil {
+		return vars, false, nil
+	}
+
+	files, err := samples.glob(pattern.glob)
+	if err != nil {
+		return vars, false, err
+	}
+	found := false
+	for _, file := range files {
+		if _, ok := pattern.match(file); !ok {
+			continue
+		}
+		records, err := newParser(parserCfg).parseFile(samples, file)
+		if err != nil {
+			return vars, false, errors.New(fmt.Sprintf("sample %s: %v", file, err))
+		}
+		for _, record := range records {
+			for k := range record {
+				vars[k] = true
+			}
+		}
+		found = true
+	}
+	return vars, found, nil
+}
+
+// Find the names of the variables that will be available for a file config
+// with inputs. The variables of each input are prefixed with the name of the
+// input.
+func joinedVars(c fileConfig, samples fileSystem) (map[string]bool, bool, error) {
+	vars := map[string]bool{}
+	for k := range defaultVars() {
+		vars[k] = true
+	}
+	for _, j := range c.Join {
+		vars[j] = true
+	}
+
+	allKnown := true
+	for _, name := range sortedKeys(c.Inputs) {
+		input := c.Inputs[name]
+		pattern, err := newFilePattern(input.File)
+		if err != nil {
+			return vars, false, err
+		}
+		inputVars, known, err := availableVars(input.Parser, pattern, samples)
+		if err != nil {
+			return vars, false, errors.New(fmt.Sprintf("input '%v': %v", name, err))
+		}
+		if c.Process != nil {
+			if c.Process.Aggregate {
+				c.Process.aggregateVars(inputVars, pattern)
+			}
+			for _, v := range c.Process.variables() {
+				inputVars[v] = true
+			}
+		}
+		for k := range inputVars {
+			vars[name+"."+k] = true
+		}
+		allKnown = allKnown && known
+	}
+	return vars, allKnown, nil
+}
+
+// Check a single file config. The samples are used to determine the
+// variables for parsers that depend on the content of the file and can be
+// nil if not available.
+func validateFileConfig(entry setfileEntry, samples fileSystem) []error {
+	errs := []error{}
+	c := entry.config
+	report := func(field string, msg string) {
+		errs = append(errs, validationError{entry.file, entry.line, entry.index, field, msg})
+	}
+
+	var vars map[string]bool
+	var known bool
+	var pattern *filePattern
+	if len(c.Inputs) > 0 {
+		if err := c.validateJoin(); err != nil {
+			report("inputs", err.Error())
+			return errs
+		}
+		for _, name := range sortedKeys(c.Inputs) {
+			if err := c.Inputs[name].Parser.validate(); err != nil {
+				report(fmt.Sprintf("inputs[%v].parser", name), err.Error())
+				return errs
+			}
+		}
+		v, k, err := joinedVars(c, samples)
+		if err != nil {
+			report("inputs", err.Error())
+		}
+		vars, known = v, k
+	} else {
+		if c.File == "" {
+			report("file", "must be specified")
+			return errs
+		}
+		p, err := newFilePattern(c.File)
+		if err != nil {
+			report("file", err.Error())
+			return errs
+		}
+		pattern = p
+
+		if err := c.Parser.validate(); err != nil {
+			report("parser", err.Error())
+			return errs
+		}
+
+		v, k, err := availableVars(c.Parser, pattern, samples)
+		if err != nil {
+			report("file", err.Error())
+		}
+		vars, known = v, k
+	}
+
+	if c.Process != nil {
+		if err := c.Process.validate(); err != nil {
+			report("process", err.Error())
+		}
+		files := []string{c.File}
+		if len(c.Inputs) > 0 {
+			files = []string{}
+			for _, name := range sortedKeys(c.Inputs) {
+				files = append(files, c.Inputs[name].File)
+			}
+		}
+		for _, file := range files {
+			if err := c.Process.validatePattern(file); err != nil {
+				report("process", err.Error())
+			}
+		}
+		if len(c.Inputs) == 0 {
+			if c.Process.Aggregate {
+				c.Process.aggregateVars(vars, pattern)
+			}
+			for _, v := range c.Process.variables() {
+				vars[v] = true
+			}
+		}
+	}
+
+	if len(c.Metrics) == 0 {
+		report("metrics", "at least one metric must be specified")
+	}
+	if err := validateUnit(c.Unit); err != nil {
+		report("unit", err.Error())
+	}
+	if err := c.Timestamp.validate(); err != nil {
+		report("timestamp", err.Error())
+	}
+
+	for i, e := range c.Enrich {
+		field := fmt.Sprintf("enrich[%d]", i)
+		if err := e.validate(); err != nil {
+			report(field, err.Error())
+			continue
+		}
+		if known && e.Key != "" && !vars[e.Key] {
+			report(field, fmt.Sprintf("variable '%v' is not produced by the parser", e.Key))
+		}
+		for _, v := range e.variables() {
+			vars[v] = true
+		}
+	}
+
+	if known && c.Timestamp.source() == timestampField && c.Timestamp.Field != "" && !vars[c.Timestamp.Field] {
+		report("timestamp", fmt.Sprintf("variable '%v' is not produced by the parser", c.Timestamp.Field))
+	}
+	if c.Filter != "" {
+		filterVars, err := checkExpr(c.Filter)
+		if err != nil {
+			report("filter", err.Error())
+		}
+		for _, v := range filterVars {
+			if known && !vars[v] {
+				report("filter", fmt.Sprintf("variable '%v' is not produced by the parser", v))
+			}
+		}
+	}
+
+	for i, r := range c.Relabel {
+		if err := r.validate(); err != nil {
+			report(fmt.Sprintf("relabel[%d]", i), err.Error())
+		}
+	}
+
+	for _, k := range sortedKeys(c.Metrics) {
+		field := fmt.Sprintf("metrics[%v]", k)
+
+		used := []string{}
+		ns, err := toNamespace(k)
+		if err != nil {
+			report(field, err.Error())
+		} else {
+			for _, e := range *ns {
+				parts := strings.Split(e.Description, ":")
+				if e.IsDynamic() && !(len(parts) == 3 && parts[1] == "path") {
+					used = append(used, e.Name)
+				}
+			}
+		}
+
+		// Same as getMetricTypes, the metric is checked after the defaults
+		// from the file config have been applied.
+		m := c.Metrics[k].inherit(c)
+		if err := m.validate(k); err != nil {
+			report(field, err.Error())
+			continue
+		}
+		exprVars, err := checkExpr(m.expr(k))
+		if err != nil {
+			report(field, err.Error())
+		}
+		used = append(used, exprVars...)
+		if filterVars, err := checkExpr(m.Filter); err == nil && m.Filter != "" {
+			used = append(used, filterVars...)
+		}
+
+		if known {
+			for _, v := range used {
+				wildcardVar := v == keyVar || v == valueVar
+				if !vars[v] && !(wildcardVar && isWildcard(k)) {
+					report(field, fmt.Sprintf("variable '%v' is not produced by the parser", v))
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+// Check for namespaces that are defined by more than one entry.
+func validateDuplicates(entries []setfileEntry) []error {
+	configs := []fileConfig{}
+	for _, entry := range entries {
+		configs = append(configs, entry.config)
+	}
+
+	errs := []error{}
+	for _, c := range namespaceCollisions(configs) {
+		entry := entries[c.index]
+		prev := entries[c.prevIndex]
+		field := fmt.Sprintf("metrics[%v]", c.metric)
+		msg := fmt.Sprintf("duplicate namespace, also defined by %s entry %d metrics[%v]", prev.file, prev.index, c.prevMetric)
+		errs = append(errs, validationError{entry.file, entry.line, entry.index, field, msg})
+	}
+	for _, entry := range entries {
+		for _, k := range sortedKeys(entry.config.Metrics) {
+			if ns, ok := reservedNamespace(k); ok {
+				field := fmt.Sprintf("metrics[%v]", k)
+				msg := fmt.Sprintf("namespace is reserved for the plugin metric '%v'", ns)
+				errs = append(errs, validationError{entry.file, entry.line, entry.index, field, msg})
+			}
+		}
+	}
+	return errs
+}
+
+// Check a setfile for problems that would otherwise only be found at
+// collection time. The setfile can be a directory or glob and the included
+// files will also be checked.
+func validateSetfile(location string, samples fileSystem) []error {
+	parts, err := loadSetfileParts(location)
+	if err != nil {
+		return []error{err}
+	}
+
+	shared, errs := sharedDefinitions(parts)
+	entries := []setfileEntry{}
+	for _, part := range parts {
+		es, partErrs := validateJsonWith(part.file, part.data, part.lines, shared)
+		errs = append(errs, partErrs...)
+		for _, entry := range es {
+			errs = append(errs, validateFileConfig(entry, samples)...)
+		}
+		entries = append(entries, es...)
+	}
+	return append(errs, validateDuplicates(entries)...)
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func errorStrings(errs []error) []string {
+	strs := []string{}
+	for _, err := range errs {
+		strs = append(strs, err.Error())
+	}
+	return strs
+}
+
+func TestValidate(t *testing.T) {
+
+	Convey("validationError", t, func() {
+		So(validationError{"a.json", 0, -1, "", "bad"}.Error(), ShouldEqual, "a.json: bad")
+		So(validationError{"a.json", 3, 1, "file", "bad"}.Error(), ShouldEqual, "a.json:3: entry 1: file: bad")
+	})
+
+	Convey("validateJson", t, func() {
+		_, errs := validateJson("a.json", []byte("[{\"file\": \"x\", \"parser\": {\"skip\": \"1\"}}]"), []int{2})
+		So(errorStrings(errs), ShouldResemble, []string{
+			"a.json:2: entry 0: parser.skip: expected uint32, found string",
+		})
+
+		_, errs = validateJson("a.json", []byte("[{\"file\": \"x\", \"metrics\": {\"/a\": \"1\", \"/b\": {\"exp\": \"1\"}}}]"), []int{2})
+		So(errorStrings(errs), ShouldResemble, []string{
+			"a.json:2: entry 0: metrics[/b].exp: unknown field",
+		})
+
+		data := `[{"file": "x", "parser": {"format": "table", "transforms": {"a": {"type": "hex", "base": 16}, "b": ["hex", {"typ": "bool"}]}}}]`
+		_, errs = validateJson("a.json", []byte(data), []int{2})
+		So(errorStrings(errs), ShouldResemble, []string{
+			"a.json:2: entry 0: parser.transforms[a].base: unknown field",
+			"a.json:2: entry 0: parser.transforms[b][1].typ: unknown field",
+		})
+
+		_, errs = validateJson("a.json", []byte(`[{"file": "x", "parser": {"normalize": {"case": "snake", "prefixes": ["total_"]}}}]`), []int{2})
+		So(errorStrings(errs), ShouldResemble, []string{
+			"a.json:2: entry 0: parser.normalize.prefixes: unknown field",
+		})
+
+		_, errs = validateJson("a.json", []byte("{\"file\": \"x\"}"), nil)
+		So(errorStrings(errs), ShouldResemble, []string{
+			"a.json: file: unknown field",
+		})
+
+		entries, errs := validateJson("a.json", []byte("{\"files\": [{\"file\": \"x\"}, {\"file\": \"y\", \"parser\": {\"skip\": 1}}]}"), []int{2, 4})
+		So(errs, ShouldBeEmpty)
+		So(len(entries), ShouldEqual, 2)
+		So(entries[1].line, ShouldEqual, 4)
+		So(entries[1].config.File, ShouldEqual, "y")
+		So(entries[1].config.Parser.Skip, ShouldEqual, uint32(1))
+	})
+
+	Convey("validateSetfile", t, func() {
+		So(validateSetfile("testdata/fileconfig.json", nil), ShouldBeEmpty)
+		So(validateSetfile("testdata/docker.json", nil), ShouldBeEmpty)
+
+		errs := validateSetfile("testdata/invalid.json", nil)
+		So(errorStrings(errs), ShouldResemble, []string{
+			"testdata/invalid.json:2: entry 0: metric: unknown field",
+			"testdata/invalid.json:2: entry 0: parser.formt: unknown field",
+			"testdata/invalid.json:55: entry 5: tags: expected map[string]string, found array",
+			"testdata/invalid.json:2: entry 0: parser: unknown file format: '', expected one of [table key-value key-row regexp procstat]",
+			"testdata/invalid.json:11: entry 1: parser: error parsing regexp: missing closing ): `(cpu\\d*\\s+(\\d+)`",
+			"testdata/invalid.json:22: entry 2: metrics[/test/load/avg05m]: unknown operator: ':pow'",
+			"testdata/invalid.json:22: entry 2: metrics[/test/load/avg15m]: variable '15mm' is not produced by the parser",
+			"testdata/invalid.json:22: entry 2: metrics[test/load/avg01m]: namespace pattern must begin with /: 'test/load/avg01m'",
+			"testdata/invalid.json:45: entry 4: metrics[/test/load/avg15m]: duplicate namespace, also defined by testdata/invalid.json entry 2 metrics[/test/load/avg15m]",
+		})
+	})
+
+	Convey("validateSetfile with samples", t, func() {
+		samples, err := loadSnapshot("testdata/host.tar.gz")
+		So(err, ShouldBeNil)
+
+		errs := validateSetfile("testdata/invalid.json", samples)
+		So(errorStrings(errs), ShouldContain,
+			"testdata/invalid.json:34: entry 3: metrics[/test/cpu/{processor}/mhz]: variable 'cpu_MHz' is not produced by the parser")
+	})
+
+	Convey("metrics are validated with the defaults of the file config", t, func() {
+		c := fileConfig{
+			File:   "testdata/loadavg",
+			Unit:   "furlongs",
+			Parser: newTableConfig([]string{"1m"}, 0),
+			Metrics: map[string]metricConfig{
+				"/test/load/a": newMetricConfig("{1m}"),
+				"/test/load/b": {Expr: "{1m}", Unit: "percent"},
+			},
+		}
+		_, err := c.getMetricTypes()
+		So(err, ShouldNotBeNil)
+
+		errs := errorStrings(validateFileConfig(setfileEntry{"a.json", 0, 2, c}, nil))
+		So(len(errs), ShouldEqual, 2)
+		So(errs[0], ShouldStartWith, "a.json:2: entry 0: unit: unknown unit: 'furlongs'")
+		So(errs[1], ShouldStartWith, "a.json:2: entry 0: metrics[/test/load/a]: unknown unit: 'furlongs'")
+	})
+
+	Convey("validate command", t, func() {
+		out := &bytes.Buffer{}
+		err := validateCommand([]string{"-setfile", "testdata/fileconfig.json"}, out)
+		So(err, ShouldBeNil)
+		So(out.String(), ShouldEqual, "testdata/fileconfig.json: ok\n")
+
+		out.Reset()
+		err = validateCommand([]string{"-setfile", "testdata/invalid.json", "-samples", "testdata/host.tar.gz"}, out)
+		So(err.Error(), ShouldEqual, "10 problems found in testdata/invalid.json")
+	})
+}
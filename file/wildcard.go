@@ -0,0 +1,118 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/intelsdi-x/snap/core"
+)
+
+// Variables set for each key when expanding a wildcard metric. A namespace
+// pattern that uses '{__key__}' will be expanded to a metric for every key
+// in a record, for example '/linux/memory/{__key__}' for /proc/meminfo. The
+// expression for the value defaults to '{__value__}'.
+const (
+	keyVar   = "__key__"
+	valueVar = "__value__"
+)
+
+var invalidKeyChars = regexp.MustCompile("[^A-Za-z0-9_]+")
+
+// Sanitize a key so it can be used as a namespace element, e.g. 'model name'
+// will be mapped to 'model_name'.
+func sanitizeKey(key string) string {
+	return strings.Trim(invalidKeyChars.ReplaceAllString(key, "_"), "_")
+}
+
+// Check if the namespace pattern should be expanded for each key.
+func isWildcard(pattern string) bool {
+	return strings.Contains(pattern, "{"+keyVar+"}")
+}
+
+// Filter for the keys of a wildcard metric based on the include and exclude
+// patterns. The patterns are matched against the key of the record before it
+// is sanitized. If the parser normalizes the keys, then that is the
+// normalized key rather than the key as it appears in the file.
+type keyFilter struct {
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+func newKeyFilter(include string, exclude string) (*keyFilter, error) {
+	f := &keyFilter{}
+	var err error
+	if include != "" {
+		if f.include, err = regexp.Compile(include); err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid include pattern: %v", err))
+		}
+	}
+	if exclude != "" {
+		if f.exclude, err = regexp.Compile(exclude); err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid exclude pattern: %v", err))
+		}
+	}
+	return f, nil
+}
+
+func (f *keyFilter) matches(key string) bool {
+	if f.include != nil && !f.include.MatchString(key) {
+		return false
+	}
+	return f.exclude == nil || !f.exclude.MatchString(key)
+}
+
+// Keys of a record that should be expanded for a wildcard metric. Only keys
+// with numeric values are used. The keys are sorted so the order of the
+// metrics is consistent.
+func wildcardKeys(record map[string]interface{}, filter *keyFilter) []string {
+	keys := []string{}
+	for k, v := range record {
+		if _, ok := v.(float64); ok && filter.matches(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Variables for a single key of a wildcard metric.
+func wildcardVars(vars map[string]interface{}, key string) map[string]interface{} {
+	result := map[string]interface{}{}
+	for k, v := range vars {
+		result[k] = v
+	}
+	result[keyVar] = sanitizeKey(key)
+	result[valueVar] = vars[key]
+	return result
+}
+
+// Replace the wildcard element of a namespace with a known key.
+func expandNamespace(ns core.Namespace, key string) core.Namespace {
+	result := core.Namespace{}
+	for _, e := range ns {
+		if e.IsDynamic() && e.Name == keyVar {
+			result = result.AddStaticElement(sanitizeKey(key))
+		} else {
+			result = append(result, e)
+		}
+	}
+	return result
+}
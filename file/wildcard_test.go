@@ -0,0 +1,127 @@
+/*
+ * Copyright 2016 Netflix, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package file
+
+import (
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWildcard(t *testing.T) {
+
+	Convey("sanitizeKey", t, func() {
+		So(sanitizeKey("total_rss"), ShouldEqual, "total_rss")
+		So(sanitizeKey("model name"), ShouldEqual, "model_name")
+		So(sanitizeKey("Hugepagesize (kB)"), ShouldEqual, "Hugepagesize_kB")
+	})
+
+	Convey("keyFilter", t, func() {
+		f, err := newKeyFilter("^total_", "pg")
+		So(err, ShouldBeNil)
+		So(f.matches("total_rss"), ShouldBeTrue)
+		So(f.matches("rss"), ShouldBeFalse)
+		So(f.matches("total_pgfault"), ShouldBeFalse)
+
+		_, err = newKeyFilter("(", "")
+		So(err.Error(), ShouldEqual, "invalid include pattern: error parsing regexp: missing closing ): `(`")
+	})
+
+	Convey("collect all keys of a record", t, func() {
+		c := fileConfig{
+			File: "testdata/memory.stat",
+			Metrics: map[string]metricConfig{
+				"/test/memory/{__key__}":       {Include: "^total_", Exclude: "pg"},
+				"/test/memory/pages/{__key__}": {Expr: "{__value__},4096,:div", Include: "^total_(rss|cache)$"},
+			},
+			Tags: map[string]string{
+				"id": "{__key__}",
+			},
+			Parser: newKeyValueConfig("\n\n", " "),
+		}
+
+		ms, err := c.collectMetrics(newCollectContext(log.New(), osFileSystem{}), nil)
+		So(err, ShouldBeNil)
+
+		values := map[string]interface{}{}
+		for _, m := range ms {
+			values[m.Namespace().String()] = m.Data()
+			if m.Namespace().String() == "/test/memory/total_rss" {
+				So(m.Tags(), ShouldResemble, map[string]string{"id": "total_rss"})
+			}
+		}
+		So(values["/test/memory/total_rss"], ShouldEqual, 327680.0)
+		So(values["/test/memory/pages/total_rss"], ShouldEqual, 80.0)
+		So(values["/test/memory/pages/total_cache"], ShouldEqual, 1.0)
+		So(values, ShouldNotContainKey, "/test/memory/rss")
+		So(values, ShouldNotContainKey, "/test/memory/total_pgfault")
+	})
+
+	Convey("keys are sanitized and string values are ignored", t, func() {
+		c := fileConfig{
+			File: "testdata/cpuinfo",
+			Metrics: map[string]metricConfig{
+				"/test/cpu/{processor}/{__key__}": newMetricConfig("{__value__}"),
+			},
+			Parser: newKeyValueConfig("\n\n", ":"),
+		}
+
+		ms, err := c.collectMetrics(newCollectContext(log.New(), osFileSystem{}), nil)
+		So(err, ShouldBeNil)
+
+		values := map[string]interface{}{}
+		for _, m := range ms {
+			values[m.Namespace().String()] = m.Data()
+		}
+		So(values["/test/cpu/0/cpu_MHz"], ShouldEqual, 2494.068)
+		So(values, ShouldNotContainKey, "/test/cpu/0/model_name")
+	})
+
+	Convey("advertise declared keys", t, func() {
+		c := fileConfig{
+			File: "testdata/memory.stat",
+			Metrics: map[string]metricConfig{
+				"/test/memory/{__key__}": {Keys: []string{"total_rss", "total_cache", "rss"}, Include: "^total_"},
+			},
+			Parser: newKeyValueConfig("\n\n", " "),
+		}
+
+		mts, err := c.getMetricTypes()
+		So(err, ShouldBeNil)
+		namespaces := []string{}
+		for _, m := range mts {
+			namespaces = append(namespaces, m.Namespace().String())
+		}
+		So(namespaces, ShouldResemble, []string{"/test/memory/total_rss", "/test/memory/total_cache"})
+
+		c.Parser = newTableConfig([]string{"key", "value"}, 0)
+		So(validateFileConfig(setfileEntry{"a.json", 0, 1, c}, nil), ShouldBeEmpty)
+
+		c.Metrics = map[string]metricConfig{"/test/memory/rss": {Expr: "{rss}", Keys: []string{"rss"}}}
+		_, err = c.getMetricTypes()
+		So(err.Error(), ShouldEqual, "metric '/test/memory/rss': include, exclude and keys can only be used with {__key__}")
+
+		c.Metrics = map[string]metricConfig{"/test/memory/{__key__}": {Include: "^total_"}}
+		_, err = c.getMetricTypes()
+		So(err.Error(), ShouldEqual, "metric '/test/memory/{__key__}': keys must be specified if the namespace ends with {__key__}")
+
+		c.Metrics = map[string]metricConfig{"/test/memory/{__key__}/value": {Include: "^total_"}}
+		mts, err = c.getMetricTypes()
+		So(err, ShouldBeNil)
+		So(mts[0].Namespace().String(), ShouldEqual, "/test/memory/*/value")
+	})
+}
@@ -16,6 +16,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/intelsdi-x/snap/control/plugin"
@@ -23,6 +24,16 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd := file.GetCommand(os.Args[1]); cmd != nil {
+			if err := cmd(os.Args[2:], os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	meta := file.Meta()
 	plugin.Start(meta, file.NewFileCollector(), os.Args[1])
 }